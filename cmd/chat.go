@@ -2,115 +2,377 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
 
-	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/google/generative-ai-go/genai"
 	"github.com/spf13/cobra"
+	"github.com/vandi/gemi/internal/attach"
 	"github.com/vandi/gemi/internal/gemini"
+	"github.com/vandi/gemi/internal/session"
 	"github.com/vandi/gemi/internal/ui"
 )
 
 var (
 	modelName  string
 	listModels bool
+	resumeID   string
 
 	chatCmd = &cobra.Command{
 		Use:   "chat",
 		Short: "Start an interactive chat with Gemini AI",
-		Long:  `Start an interactive chat session with Gemini AI in your terminal.`,
-		Run: func(cmd *cobra.Command, args []string) {
+		Long: `Start an interactive chat session with Gemini AI in your terminal.
+
+Conversations are saved automatically under $XDG_DATA_HOME/gemi/sessions.
+Continue one later with --resume ID, or --resume last for the most recent.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
 			// If --list-models flag is provided, list models and exit
 			if listModels {
-				modelsCmd.Run(cmd, args)
-				return
+				return modelsCmd.RunE(cmd, args)
 			}
 
-			apiKey, err := getApiKey()
-			if err != nil {
-				fmt.Println(ui.ErrorPrefix + err.Error())
-				return
+			// Notices on stderr would garble the chat UI
+			notices = false
+
+			// Pick up a saved conversation where it left off, unless flags
+			// ask for a different model or system instruction
+			var saved *session.Session
+			if resumeID != "" {
+				var err error
+				saved, err = session.Resolve(resumeID)
+				if err != nil {
+					return usageError(fmt.Errorf("failed to resume session: %w", err))
+				}
+				if !cmd.Flags().Changed("model") && saved.Model != "" {
+					modelName = saved.Model
+				}
+				if !cmd.Flags().Changed("system") && !cmd.Flags().Changed("system-file") {
+					settings.SystemInstruction = saved.SystemInstruction
+				}
 			}
 
-			client, err := gemini.NewClient(apiKey, modelName)
+			client, err := newProvider(cmd.Context(), modelName)
 			if err != nil {
-				fmt.Println(ui.ErrorPrefix + "Failed to initialize Gemini client: " + err.Error())
-				return
+				return err
 			}
 			defer client.Close()
 
+			chatSession := client.StartChat()
+			if saved != nil {
+				chatSession.SetHistory(saved.GenaiHistory())
+			} else {
+				saved = session.New(modelName, settings.SystemInstruction)
+			}
+
 			// Start the chat UI
-			p := tea.NewProgram(initialChatModel(client, client.StartChat()))
+			p := tea.NewProgram(initialChatModel(cmd.Context(), client, chatSession, saved), tea.WithAltScreen(), tea.WithMouseCellMotion())
 			if _, err := p.Run(); err != nil {
-				fmt.Println(ui.ErrorPrefix + "Error running chat: " + err.Error())
+				return fmt.Errorf("error running chat: %w", err)
 			}
+			return nil
 		},
 	}
 )
 
 func init() {
-	chatCmd.Flags().StringVar(&modelName, "model", "gemini-1.5-pro-latest", "Gemini model to use")
+	chatCmd.Flags().StringVar(&modelName, "model", "", "Gemini model to use (default from profile, or "+gemini.DefaultModel+")")
+	addGenerationFlags(chatCmd)
+	chatCmd.Flags().IntVar(&maxInputTokens, "max-input-tokens", 0, "Refuse to send a message that takes the conversation over this many input tokens")
 	chatCmd.Flags().BoolVar(&listModels, "list-models", false, "List available Gemini models")
+	chatCmd.Flags().StringVar(&resumeID, "resume", "", "Resume a saved session by ID, or \"last\" for the most recent one")
 }
 
 // Chat UI model
 type chatModel struct {
-	client       *gemini.Client
-	chatSession  *genai.ChatSession
+	// ctx is cancelled when gemi is told to stop, ending any request
+	ctx context.Context
+
+	client       gemini.Provider
+	chatSession  gemini.ChatSession
 	messages     []message
-	textInput    textinput.Model
+	composer     textarea.Model
+	viewport     viewport.Model
 	err          error
 	width        int
 	height       int
 	currentModel string
+
+	// systemInstruction is the instruction the current session was started with
+	systemInstruction string
+
+	// contextTokens is the size of the conversation in input tokens, and
+	// tokenLimit the current model's input limit, or 0 if unknown
+	contextTokens int32
+	tokenLimit    int32
+
+	// pending holds media queued with /attach for the next message
+	pending []attach.Media
+
+	// session is the saved form of the conversation, which starts at
+	// messages[sessionStart]
+	session      *session.Session
+	sessionStart int
+
+	// picker lists saved sessions while /sessions is open
+	picker sessionPicker
+
+	// stream is the reply being received, if any, and streamReply the text
+	// that has arrived so far
+	stream      *chatStream
+	streamReply string
+	spinner     spinner.Model
+
+	// markdown caches rendered replies so redrawing the transcript doesn't
+	// re-render the whole history
+	markdown *ui.MarkdownCache
+}
+
+// chatStream is an in-flight streamed reply
+type chatStream struct {
+	msgs   chan tea.Msg
+	cancel context.CancelFunc
+}
+
+// sessionPicker is the /sessions list of saved conversations
+type sessionPicker struct {
+	active   bool
+	sessions []*session.Session
+	cursor   int
 }
 
+// messageKind is what a chat message is, deciding how it's shown and saved
+type messageKind int
+
+const (
+	kindUser  messageKind = iota // a message to the model
+	kindReply                    // the model's reply
+	kindNote                     // an informational line from gemi itself, such as attachments
+
+	// Slash commands and their output are shown like messages and replies,
+	// but saved as notes since they aren't part of the conversation
+	kindCommand
+	kindOutput
+)
+
 type message struct {
 	content string
-	isUser  bool
+	kind    messageKind
+
+	// usage is the token usage reported for a model reply
+	usage *session.Usage
+}
+
+// transcript converts a saved session's messages for the chat view
+func transcript(saved *session.Session) []message {
+	messages := []message{}
+	for _, sm := range saved.Messages {
+		kind := kindReply
+		switch {
+		case sm.Role == session.RoleUser:
+			kind = kindUser
+		case sm.Kind == session.KindCommand:
+			kind = kindCommand
+		case sm.Kind == session.KindOutput:
+			kind = kindOutput
+		case sm.Role == session.RoleNote:
+			kind = kindNote
+		}
+		messages = append(messages, message{content: sm.Content, kind: kind, usage: sm.Usage})
+	}
+	return messages
 }
 
-func initialChatModel(client *gemini.Client, chatSession *genai.ChatSession) chatModel {
-	ti := textinput.New()
-	ti.Placeholder = "Type your message and press Enter (Ctrl+C to quit)"
-	ti.Focus()
-	ti.Width = 80
+func initialChatModel(ctx context.Context, client gemini.Provider, chatSession gemini.ChatSession, saved *session.Session) chatModel {
+	ta := textarea.New()
+	ta.Placeholder = "Type your message and press Enter (Alt+Enter for a new line)"
+	ta.ShowLineNumbers = false
+	ta.SetPromptFunc(2, func(line int) string {
+		if line == 0 {
+			return "> "
+		}
+		return "  "
+	})
+	ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
+	ta.CharLimit = 0
+	ta.MaxHeight = 0
+	ta.SetWidth(78)
+	ta.SetHeight(1)
+	ta.Focus()
+
+	// Enter sends, so newlines need a modifier. Home and End scroll the
+	// transcript and Ctrl+E opens the editor, leaving Ctrl+A for line start
+	// and Ctrl+] for line end.
+	ta.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("alt+enter", "ctrl+j"))
+	ta.KeyMap.LineStart = key.NewBinding(key.WithKeys("ctrl+a"))
+	ta.KeyMap.LineEnd = key.NewBinding(key.WithKeys("ctrl+]"))
 
-	return chatModel{
+	// Only page keys scroll the viewport, so typing never does
+	vp := viewport.New(80, 24-chatChromeHeight)
+	vp.KeyMap = viewport.KeyMap{
+		PageDown: key.NewBinding(key.WithKeys("pgdown")),
+		PageUp:   key.NewBinding(key.WithKeys("pgup")),
+	}
+
+	sp := spinner.New(
+		spinner.WithSpinner(spinner.Dot),
+		spinner.WithStyle(lipgloss.NewStyle().Foreground(lipgloss.Color(ui.SecondaryColor))),
+	)
+
+	// Restore the transcript of a resumed session
+	m := chatModel{
+		ctx:          ctx,
 		client:       client,
 		chatSession:  chatSession,
-		textInput:    ti,
-		messages:     []message{},
+		composer:     ta,
+		viewport:     vp,
+		messages:     transcript(saved),
 		width:        80,
 		height:       24,
 		currentModel: modelName,
+		spinner:      sp,
+		markdown:     ui.NewMarkdownCache(),
+
+		systemInstruction: client.Settings().SystemInstruction,
+		session:           saved,
 	}
+	m.layout()
+	m.refresh()
+	return m
 }
 
 func (m chatModel) Init() tea.Cmd {
-	return textinput.Blink
+	return tea.Batch(textarea.Blink, m.fetchTokenLimit(), m.countContext(m.chatSession))
 }
 
 func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	model, cmd := m.update(msg)
+
+	// Save the conversation whenever the transcript grows
+	updated := model.(chatModel)
+	if len(updated.messages)-updated.sessionStart > len(updated.session.Messages) {
+		if err := updated.persist(); err != nil {
+			updated.err = err
+		}
+	}
+
+	updated.layout()
+	if transcriptChanged(m, updated) {
+		updated.refresh()
+	}
+	return updated, cmd
+}
+
+// transcriptChanged reports whether an update changed what the transcript
+// shows
+func transcriptChanged(before chatModel, after chatModel) bool {
+	return len(before.messages) != len(after.messages) ||
+		before.session != after.session ||
+		(before.stream == nil) != (after.stream == nil) ||
+		before.streamReply != after.streamReply ||
+		before.err != after.err ||
+		before.viewport.Width != after.viewport.Width ||
+		before.viewport.Height != after.viewport.Height
+}
+
+// persist appends new transcript messages to the saved session, syncs the
+// model history and writes the session to disk. Sessions are only written
+// once they contain a real message rather than just commands.
+func (m chatModel) persist() error {
+	now := time.Now()
+	for _, msg := range m.messages[m.sessionStart+len(m.session.Messages):] {
+		saved := session.Message{Role: session.RoleNote, Content: msg.content, Time: now, Usage: msg.usage}
+		switch msg.kind {
+		case kindUser:
+			saved.Role = session.RoleUser
+		case kindReply:
+			saved.Role = session.RoleModel
+		case kindCommand:
+			saved.Kind = session.KindCommand
+		case kindOutput:
+			saved.Kind = session.KindOutput
+		}
+		m.session.Messages = append(m.session.Messages, saved)
+	}
+
+	m.session.Model = m.currentModel
+	m.session.SystemInstruction = m.systemInstruction
+	if m.stream == nil {
+		// A streaming reply still updates the history; sync it once done
+		m.session.SetHistory(m.chatSession.History())
+	}
+	m.session.Touch()
+
+	if m.session.Title == "" {
+		return nil
+	}
+	return session.Save(m.session)
+}
+
+func (m chatModel) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.picker.active && msg.Type != tea.KeyCtrlC {
+			return m.updatePicker(msg)
+		}
+
+		switch msg.String() {
+		case "pgup", "pgdown":
+			m.viewport, cmd = m.viewport.Update(msg)
+			return m, cmd
+		case "home":
+			m.viewport.GotoTop()
+			return m, nil
+		case "end":
+			m.viewport.GotoBottom()
+			return m, nil
+		}
+
 		switch msg.Type {
 		case tea.KeyCtrlC:
+			if m.stream != nil {
+				m.stream.cancel()
+			}
 			return m, tea.Quit
+		case tea.KeyEsc:
+			if m.stream != nil {
+				// The partial reply arrives with streamDoneMsg
+				m.stream.cancel()
+				return m, nil
+			}
+		case tea.KeyCtrlE:
+			return m, m.openEditor()
 		case tea.KeyEnter:
-			if m.textInput.Value() == "" {
+			// Alt+Enter inserts a newline
+			if msg.Alt {
+				break
+			}
+
+			// Wait for the current reply before sending anything else
+			if strings.TrimSpace(m.composer.Value()) == "" || m.stream != nil {
 				return m, nil
 			}
 
-			userInput := m.textInput.Value()
-			m.messages = append(m.messages, message{content: userInput, isUser: true})
-			m.textInput.Reset()
+			userInput := strings.TrimSpace(m.composer.Value())
+			kind := kindUser
+			if isChatCommand(userInput) {
+				kind = kindCommand
+			}
+			m.messages = append(m.messages, message{content: userInput, kind: kind})
+			m.composer.Reset()
 
 			// Check for special commands
 			if strings.HasPrefix(userInput, "/model ") {
@@ -123,15 +385,53 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 
 					// Create a new chat session with the new model
-					m.chatSession = m.client.StartChat()
-					m.currentModel = newModel
+					return sessionResetMsg{
+						session: m.client.StartChat(),
+						model:   newModel,
+						system:  m.systemInstruction,
+						content: "Switched to model: " + newModel,
+					}
+				}
+			} else if userInput == "/system" || strings.HasPrefix(userInput, "/system ") {
+				// Command to show or change the system instruction
+				instruction := strings.TrimSpace(strings.TrimPrefix(userInput, "/system"))
+				if instruction == "" {
+					return m, func() tea.Msg {
+						if m.systemInstruction == "" {
+							return responseMsg{content: "No system instruction is set. To set one, type: `/system INSTRUCTION`"}
+						}
+						return responseMsg{content: "**System instruction:**\n\n> " + m.systemInstruction + "\n\nTo clear it, type: `/system clear`"}
+					}
+				}
+				if instruction == "clear" {
+					instruction = ""
+				}
+
+				return m, func() tea.Msg {
+					settings := m.client.Settings()
+					settings.SystemInstruction = instruction
+					if err := m.client.SetSettings(settings); err != nil {
+						return errorMsg{err}
+					}
 
-					return responseMsg{content: "Switched to model: " + newModel}
+					// The instruction applies to a whole conversation, so start a new one
+					content := "System instruction cleared. Started a new conversation."
+					if instruction != "" {
+						content = "System instruction set. Started a new conversation."
+					}
+					return sessionResetMsg{
+						session: m.client.StartChat(),
+						model:   m.currentModel,
+						system:  instruction,
+						content: content,
+					}
 				}
 			} else if userInput == "/models" || userInput == "/list-models" {
 				// Command to list available models in Markdown format
 				return m, func() tea.Msg {
-					models, err := m.client.ListModels()
+					ctx, cancel := requestContext(m.ctx)
+					defer cancel()
+					models, err := m.client.ListModels(ctx)
 					if err != nil {
 						return errorMsg{err}
 					}
@@ -172,106 +472,534 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 					return responseMsg{content: sb.String()}
 				}
+			} else if userInput == "/set" || strings.HasPrefix(userInput, "/set ") {
+				// Command to show or change a generation setting
+				fields := strings.Fields(userInput)
+				return m, func() tea.Msg {
+					settings := m.client.Settings()
+					if len(fields) == 1 {
+						return responseMsg{content: "**Generation settings:** " + settings.String() + "\n\n" +
+							"To change a setting, type: `/set NAME VALUE` (`/set NAME default` to reset)\n\n" +
+							"Settings: `" + strings.Join(gemini.SettingKeys, "`, `") + "`"}
+					}
+
+					value := strings.Join(fields[2:], " ")
+					if err := settings.Set(fields[1], value); err != nil {
+						return errorMsg{err}
+					}
+					if err := m.client.SetSettings(settings); err != nil {
+						return errorMsg{err}
+					}
+
+					return responseMsg{content: "**Generation settings:** " + settings.String()}
+				}
+			} else if userInput == "/attach" || strings.HasPrefix(userInput, "/attach ") {
+				// Command to queue media for the next message
+				path := strings.TrimSpace(strings.TrimPrefix(userInput, "/attach"))
+				switch path {
+				case "":
+					if len(m.pending) == 0 {
+						m.messages = append(m.messages, message{content: "No attachments queued. To attach a file, type: /attach PATH", kind: kindNote})
+					}
+					for _, media := range m.pending {
+						m.messages = append(m.messages, message{content: "Queued " + media.Describe(), kind: kindNote})
+					}
+				case "clear":
+					m.pending = nil
+					m.messages = append(m.messages, message{content: "Attachments cleared", kind: kindNote})
+				default:
+					media, err := attach.LoadMedia(path, attach.KindAny)
+					if err == nil {
+						err = attach.CheckMediaSize(append(m.pending[:len(m.pending):len(m.pending)], media))
+					}
+					if err != nil {
+						m.err = err
+						return m, nil
+					}
+					m.pending = append(m.pending, media)
+					m.messages = append(m.messages, message{content: "Queued " + media.Describe() + " for your next message", kind: kindNote})
+				}
+				return m, nil
+			} else if userInput == "/sessions" {
+				// Command to pick a saved conversation to continue
+				sessions, err := session.List()
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				if len(sessions) == 0 {
+					m.messages = append(m.messages, message{content: "No saved sessions yet", kind: kindNote})
+					return m, nil
+				}
+				m.picker = sessionPicker{active: true, sessions: sessions}
+				return m, nil
+			} else if userInput == "/export" || strings.HasPrefix(userInput, "/export ") {
+				// Command to write the conversation to a file
+				path := strings.TrimSpace(strings.TrimPrefix(userInput, "/export"))
+				if path == "" {
+					m.messages = append(m.messages, message{content: "To export this conversation, type: /export PATH (.md, .json, .html or .jsonl)", kind: kindNote})
+					return m, nil
+				}
+				if err := exportSession(m.session, path, session.FormatFromPath(path)); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.messages = append(m.messages, message{content: "Exported conversation to " + path, kind: kindNote})
+				return m, nil
 			} else if userInput == "/help" {
 				// Command to show help in Markdown format
 				return m, func() tea.Msg {
 					help := "# Available Commands\n\n" +
 						"* **`/models`** or **`/list-models`** - List available models\n" +
 						"* **`/model MODEL_NAME`** - Switch to a different model\n" +
+						"* **`/system INSTRUCTION`** - Set the system instruction and start a new conversation (`/system clear` removes it)\n" +
+						"* **`/set NAME VALUE`** - Change a generation setting such as `temperature` (`/set` shows them)\n" +
+						"* **`/attach PATH`** - Send an image, PDF or audio file with your next message (`/attach clear` drops them)\n" +
+						"* **`/sessions`** - Pick a saved conversation to continue\n" +
+						"* **`/export PATH`** - Save the conversation as Markdown, JSON, HTML or JSONL, chosen by extension\n" +
+						"* **`@path`** - Mention a file, directory or glob in a message to include its contents\n" +
 						"* **`/help`** - Show this help message\n" +
+						"* **`Alt+Enter`** - Start a new line (`Ctrl+J` also works); **`Ctrl+E`** - Write the message in `$EDITOR`\n" +
+						"* **`Ctrl+A`** / **`Ctrl+]`** - Move to the start / end of the line\n" +
 						"* **`/quit`** or **`Ctrl+C`** - Exit the chat"
 					return responseMsg{content: help}
 				}
 			} else if userInput == "/quit" {
 				return m, tea.Quit
 			} else {
-				// Regular message to Gemini
-				return m, func() tea.Msg {
-					ctx := context.Background()
-					resp, err := m.chatSession.SendMessage(ctx, genai.Text(userInput))
-					if err != nil {
-						return errorMsg{err}
-					}
-
-					var aiResponse string
-					for _, candidate := range resp.Candidates {
-						if candidate.Content != nil {
-							for _, part := range candidate.Content.Parts {
-								if text, ok := part.(genai.Text); ok {
-									aiResponse += string(text)
-								}
-							}
-						}
-					}
+				// Regular message to Gemini, with any @path files inlined
+				parts, notes, err := chatParts(userInput)
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				for _, note := range notes {
+					m.messages = append(m.messages, message{content: note, kind: kindNote})
+				}
 
-					return responseMsg{content: aiResponse}
+				// Send any queued media along with the message
+				if len(m.pending) > 0 {
+					parts = append(attach.MediaParts(m.pending), parts...)
+					m.pending = nil
 				}
+
+				return m.startStream(parts)
+			}
+		}
+
+	case streamChunkMsg:
+		m.streamReply += msg.text
+		return m, waitForStream(m.stream.msgs)
+
+	case streamDoneMsg:
+		m.stream.cancel()
+		reply := m.streamReply
+		m.stream = nil
+		m.streamReply = ""
+
+		switch {
+		case msg.cancelled:
+			if reply != "" {
+				m.messages = append(m.messages, message{content: reply, kind: kindReply})
+			}
+			m.messages = append(m.messages, message{content: "Reply cancelled", kind: kindNote})
+		case msg.err != nil:
+			// The history keeps whatever part of the reply arrived
+			if reply != "" {
+				m.messages = append(m.messages, message{content: reply, kind: kindReply})
 			}
+			m.err = msg.err
+		default:
+			m.messages = append(m.messages, message{content: gemini.ResponseText(msg.resp), kind: kindReply, usage: session.UsageFrom(msg.resp.UsageMetadata)})
+		}
+		// Count the conversation again with the reply, or the part of it
+		// that joined the history, rather than trusting streamed usage
+		return m, m.countContext(m.chatSession)
+
+	case contextTokensMsg:
+		// Ignore counts for a conversation that has since been replaced
+		if msg.session == m.chatSession {
+			m.contextTokens = msg.tokens
+		}
+
+	case tokenLimitMsg:
+		if msg.model == m.currentModel {
+			m.tokenLimit = msg.limit
+		}
+
+	case spinner.TickMsg:
+		// Let the spinner stop once nothing is streaming
+		if m.stream == nil {
+			return m, nil
 		}
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
 
 	case responseMsg:
-		m.messages = append(m.messages, message{content: msg.content, isUser: false})
+		m.messages = append(m.messages, message{content: msg.content, kind: kindOutput})
+
+	case sessionResetMsg:
+		switched := msg.model != m.currentModel
+		m.chatSession = msg.session
+		m.currentModel = msg.model
+		m.systemInstruction = msg.system
+		m.messages = append(m.messages, message{content: msg.content, kind: kindOutput})
+		m.contextTokens = 0
+
+		// The old conversation is over; save the new one separately
+		m.session = session.New(msg.model, msg.system)
+		m.sessionStart = len(m.messages)
+		if switched {
+			m.tokenLimit = 0
+			return m, m.fetchTokenLimit()
+		}
+
+	case sessionLoadedMsg:
+		// Show the picked conversation and keep saving into it
+		switched := msg.session.Model != m.currentModel
+		m.chatSession = msg.chatSession
+		m.currentModel = msg.session.Model
+		m.systemInstruction = msg.session.SystemInstruction
+		m.session = msg.session
+		m.sessionStart = 0
+		m.pending = nil
+		m.err = nil
+		m.messages = transcript(msg.session)
+
+		cmds := []tea.Cmd{m.countContext(m.chatSession)}
+		if switched {
+			m.tokenLimit = 0
+			cmds = append(cmds, m.fetchTokenLimit())
+		}
+		return m, tea.Batch(cmds...)
 
 	case errorMsg:
 		m.err = msg.err
 
+	case editorDoneMsg:
+		m.composer.SetValue(msg.text)
+
+	case tea.MouseMsg:
+		m.viewport, cmd = m.viewport.Update(msg)
+		return m, cmd
+
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
 	}
 
-	m.textInput, cmd = m.textInput.Update(msg)
+	m.composer, cmd = m.composer.Update(msg)
 	return m, cmd
 }
 
+// maxComposerHeight is the most lines the composer grows to before scrolling
+const maxComposerHeight = 8
+
+// layout sizes the composer to its contents and gives the transcript the
+// rest of the screen
+func (m *chatModel) layout() {
+	m.composer.SetWidth(max(10, m.width-2))
+	m.composer.SetHeight(min(max(1, m.composer.LineCount()), maxComposerHeight))
+	m.viewport.Width = m.width
+	m.viewport.Height = max(1, m.height-chatChromeHeight-(m.composer.Height()-1))
+}
+
+// openEditor hands the composer text to $VISUAL or $EDITOR in a temporary
+// file and loads the result back once the editor exits
+func (m chatModel) openEditor() tea.Cmd {
+	f, err := os.CreateTemp("", "gemi-*.md")
+	if err != nil {
+		return func() tea.Msg {
+			return errorMsg{fmt.Errorf("failed to create temporary file: %v", err)}
+		}
+	}
+	path := f.Name()
+	_, err = f.WriteString(m.composer.Value())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return func() tea.Msg {
+			return errorMsg{fmt.Errorf("failed to write temporary file: %v", err)}
+		}
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := strings.Fields(editor)
+
+	return tea.ExecProcess(exec.Command(args[0], append(args[1:], path)...), func(err error) tea.Msg {
+		defer os.Remove(path)
+		if err != nil {
+			return errorMsg{fmt.Errorf("editor %s failed: %v", args[0], err)}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return errorMsg{fmt.Errorf("failed to read edited message: %v", err)}
+		}
+		return editorDoneMsg{text: strings.TrimRight(string(data), "\n")}
+	})
+}
+
+// countContext counts the tokens of a conversation in the background. The
+// header just keeps the old count if counting fails.
+func (m chatModel) countContext(chatSession gemini.ChatSession) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := requestContext(m.ctx)
+		defer cancel()
+		tokens, err := chatSession.CountTokens(ctx)
+		if err != nil {
+			return nil
+		}
+		return contextTokensMsg{session: chatSession, tokens: tokens}
+	}
+}
+
+// fetchTokenLimit looks up the current model's input token limit in the
+// background. The header leaves the limit out if that fails.
+func (m chatModel) fetchTokenLimit() tea.Cmd {
+	model := m.currentModel
+	return func() tea.Msg {
+		ctx, cancel := requestContext(m.ctx)
+		defer cancel()
+		info, err := m.client.ModelInfo(ctx)
+		if err != nil {
+			return nil
+		}
+		return tokenLimitMsg{model: model, limit: info.InputTokenLimit}
+	}
+}
+
+// startStream sends a message in the background and streams the reply back
+// as streamChunkMsg and streamDoneMsg messages
+func (m chatModel) startStream(parts []genai.Part) (tea.Model, tea.Cmd) {
+	ctx, cancel := requestContext(m.ctx)
+	msgs := make(chan tea.Msg)
+	chatSession := m.chatSession
+
+	go func() {
+		defer close(msgs)
+		if err := checkInputTokens(ctx, chatSession, parts); err != nil {
+			msgs <- streamDoneMsg{err: err, cancelled: errors.Is(ctx.Err(), context.Canceled)}
+			return
+		}
+		resp, err := chatSession.SendMessageStream(ctx, func(text string) {
+			msgs <- streamChunkMsg{text: text}
+		}, parts...)
+		msgs <- streamDoneMsg{resp: resp, err: err, cancelled: errors.Is(ctx.Err(), context.Canceled)}
+	}()
+
+	m.err = nil
+	m.stream = &chatStream{msgs: msgs, cancel: cancel}
+	return m, tea.Batch(waitForStream(msgs), m.spinner.Tick)
+}
+
+// waitForStream delivers the next message of a streamed reply
+func waitForStream(msgs chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-msgs
+		if !ok {
+			return nil
+		}
+		return msg
+	}
+}
+
+// updatePicker handles keys while the /sessions picker is open
+func (m chatModel) updatePicker(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "up", "k", "ctrl+p":
+		if m.picker.cursor > 0 {
+			m.picker.cursor--
+		}
+	case "down", "j", "ctrl+n":
+		if m.picker.cursor < len(m.picker.sessions)-1 {
+			m.picker.cursor++
+		}
+	case "esc", "q":
+		m.picker = sessionPicker{}
+	case "enter":
+		saved := m.picker.sessions[m.picker.cursor]
+		m.picker = sessionPicker{}
+		return m, m.loadSession(saved)
+	}
+	return m, nil
+}
+
+// loadSession switches the client to a saved session's model and system
+// instruction and starts a chat with its history
+func (m chatModel) loadSession(saved *session.Session) tea.Cmd {
+	return func() tea.Msg {
+		if saved.Model == "" {
+			saved.Model = m.currentModel
+		}
+		if saved.Model != m.currentModel {
+			if err := m.client.SwitchModel(saved.Model); err != nil {
+				return errorMsg{err}
+			}
+		}
+
+		settings := m.client.Settings()
+		if settings.SystemInstruction != saved.SystemInstruction {
+			settings.SystemInstruction = saved.SystemInstruction
+			if err := m.client.SetSettings(settings); err != nil {
+				return errorMsg{err}
+			}
+		}
+
+		chatSession := m.client.StartChat()
+		chatSession.SetHistory(saved.GenaiHistory())
+		return sessionLoadedMsg{chatSession: chatSession, session: saved}
+	}
+}
+
+// pickerView renders the /sessions picker, scrolled to keep the cursor visible
+func (m chatModel) pickerView() string {
+	var s strings.Builder
+	gray := lipgloss.NewStyle().Foreground(lipgloss.Color("#888888"))
+
+	s.WriteString(ui.SubtitleStyle.Render("Saved sessions") + "\n\n")
+
+	// Each session takes two lines
+	visible := max(1, (m.height-8)/2)
+	start := 0
+	if m.picker.cursor >= visible {
+		start = m.picker.cursor - visible + 1
+	}
+	end := min(len(m.picker.sessions), start+visible)
+
+	for i := start; i < end; i++ {
+		saved := m.picker.sessions[i]
+		title := truncate(saved.Title, max(20, m.width-4))
+		details := fmt.Sprintf("%s · %s · %d messages · %s", saved.ID, saved.Model, saved.MessageCount(), humanizeAge(saved.UpdatedAt))
+		if saved.ID == m.session.ID {
+			details += " · current"
+		}
+
+		if i == m.picker.cursor {
+			s.WriteString(ui.UserPromptStyle.Render("› "+title) + "\n")
+		} else {
+			s.WriteString("  " + title + "\n")
+		}
+		s.WriteString("  " + gray.Render(details) + "\n")
+	}
+
+	s.WriteString("\n" + gray.Render("↑/↓ to move, Enter to open, Esc to cancel") + "\n")
+	return s.String()
+}
+
 func (m chatModel) View() string {
 	var s strings.Builder
 
-	// Title with current model
+	// Title with current model, context size and system instruction
 	title := ui.RenderTitle(" Gemini Chat - " + m.currentModel + " ")
+	title += " " + ui.SubtitleStyle.Render(m.contextLabel())
+	if m.systemInstruction != "" {
+		title += " " + ui.SubtitleStyle.Render("System: "+truncate(m.systemInstruction, max(20, m.width-lipgloss.Width(title)-10)))
+	}
 	s.WriteString(title + "\n\n")
 
-	// Messages
-	if len(m.messages) == 0 {
-		s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#888888")).Render("Start chatting with Gemini AI...") + "\n")
-		s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#888888")).Render("Type /help to see available commands") + "\n\n")
-	} else {
-		// Calculate available height for messages
-		availableHeight := m.height - 7 // Adjust based on other UI elements
+	if m.picker.active {
+		s.WriteString(m.pickerView())
+		return s.String()
+	}
 
-		// If we have more messages than can fit, show only the most recent ones
-		startIdx := 0
-		if len(m.messages) > availableHeight/2 {
-			startIdx = len(m.messages) - availableHeight/2
-		}
+	// Transcript
+	s.WriteString(m.viewport.View() + "\n\n")
 
-		for i := startIdx; i < len(m.messages); i++ {
-			msg := m.messages[i]
-			if msg.isUser {
-				s.WriteString(ui.RenderUserPrompt(msg.content) + "\n\n")
+	// Input field
+	gray := lipgloss.NewStyle().Foreground(lipgloss.Color("#888888"))
+	s.WriteString(m.composer.View() + "\n")
+	switch {
+	case m.stream != nil:
+		s.WriteString(m.spinner.View() + gray.Render(" Press Esc to stop the reply, Ctrl+C to quit"))
+	case !m.viewport.AtBottom():
+		s.WriteString(gray.Render(fmt.Sprintf("%3.0f%% · PgUp/PgDn to scroll, End to jump to the latest message", m.viewport.ScrollPercent()*100)))
+	default:
+		s.WriteString(gray.Render("Press Ctrl+C to quit"))
+	}
+
+	return s.String()
+}
+
+// contextLabel describes the size of the conversation against the model's
+// input token limit
+func (m chatModel) contextLabel() string {
+	if m.tokenLimit == 0 {
+		return fmt.Sprintf("Context: %d tokens", m.contextTokens)
+	}
+	return fmt.Sprintf("Context: %d / %d tokens (%s)", m.contextTokens, m.tokenLimit, percent(m.contextTokens, m.tokenLimit))
+}
+
+// chatChromeHeight is the number of lines around the transcript: the title
+// and a blank line above it, and a blank line, the input and the help line
+// below it
+const chatChromeHeight = 5
+
+// refresh re-renders the transcript into the viewport, following new output
+// unless the user has scrolled up
+func (m *chatModel) refresh() {
+	follow := m.viewport.AtBottom()
+	m.viewport.SetContent(m.renderTranscript())
+	if follow {
+		m.viewport.GotoBottom()
+	}
+}
+
+// renderTranscript renders the messages, any reply being streamed and the
+// last error, wrapped to the terminal width
+func (m chatModel) renderTranscript() string {
+	var s strings.Builder
+	gray := lipgloss.NewStyle().Foreground(lipgloss.Color("#888888"))
+	wrap := lipgloss.NewStyle().Width(m.viewport.Width)
+	markdownWidth := max(20, m.viewport.Width-2)
+
+	if len(m.messages) == 0 && m.stream == nil {
+		s.WriteString(gray.Render("Start chatting with Gemini AI...") + "\n")
+		s.WriteString(gray.Render("Type /help to see available commands") + "\n\n")
+	}
+
+	for _, msg := range m.messages {
+		if msg.kind == kindUser || msg.kind == kindCommand {
+			s.WriteString(wrap.Render(ui.RenderUserPrompt(msg.content)) + "\n\n")
+		} else if msg.kind == kindNote {
+			s.WriteString(wrap.Render(ui.InfoPrefix+gray.Render(msg.content)) + "\n\n")
+		} else {
+			// Apply Markdown formatting to AI responses using Glamour
+			formattedContent, err := m.markdown.Render(msg.content, markdownWidth)
+			if err != nil {
+				s.WriteString(ui.ErrorPrefix + "Failed to render markdown: " + err.Error() + "\n\n")
+				s.WriteString(ui.AIResponseStyle.Render("Gemini: ") + "\n\n" + wrap.Render(msg.content) + "\n\n")
 			} else {
-				// Apply Markdown formatting to AI responses using Glamour
-				formattedContent, err := ui.RenderMarkdownWithGlamour(msg.content)
-				if err != nil {
-					s.WriteString(ui.ErrorPrefix + "Failed to render markdown: " + err.Error() + "\n\n")
-					s.WriteString(ui.AIResponseStyle.Render("Gemini: ") + "\n\n" + msg.content + "\n\n")
-				} else {
-					s.WriteString(ui.AIResponseStyle.Render("Gemini: ") + "\n\n" + formattedContent + "\n")
-				}
+				s.WriteString(ui.AIResponseStyle.Render("Gemini: ") + "\n\n" + formattedContent + "\n")
 			}
 		}
 	}
 
+	// Reply being streamed
+	if m.stream != nil {
+		if m.streamReply == "" {
+			s.WriteString(gray.Render("Thinking…") + "\n\n")
+		} else if formattedContent, err := ui.RenderMarkdownWidth(m.streamReply, markdownWidth); err == nil {
+			s.WriteString(ui.AIResponseStyle.Render("Gemini: ") + "\n\n" + formattedContent + "\n")
+		} else {
+			s.WriteString(ui.AIResponseStyle.Render("Gemini: ") + "\n\n" + wrap.Render(m.streamReply) + "\n\n")
+		}
+	}
+
 	// Error message
 	if m.err != nil {
-		s.WriteString(ui.ErrorPrefix + m.err.Error() + "\n\n")
+		s.WriteString(wrap.Render(ui.ErrorPrefix+m.err.Error()) + "\n\n")
 	}
 
-	// Input field
-	s.WriteString(m.textInput.View() + "\n")
-	s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#888888")).Render("Press Ctrl+C to quit") + "\n")
-
-	return s.String()
+	return strings.TrimRight(s.String(), "\n")
 }
 
 // Message types for the tea.Program
@@ -282,3 +1010,124 @@ type responseMsg struct {
 type errorMsg struct {
 	err error
 }
+
+// sessionResetMsg replaces the chat session after a model or system
+// instruction change
+type sessionResetMsg struct {
+	session gemini.ChatSession
+	model   string
+	system  string
+	content string
+}
+
+// streamChunkMsg is a piece of a streamed reply
+type streamChunkMsg struct {
+	text string
+}
+
+// streamDoneMsg ends a streamed reply
+type streamDoneMsg struct {
+	resp      *genai.GenerateContentResponse
+	err       error
+	cancelled bool
+}
+
+// contextTokensMsg is the size of a conversation in input tokens
+type contextTokensMsg struct {
+	session gemini.ChatSession
+	tokens  int32
+}
+
+// tokenLimitMsg is the input token limit of a model
+type tokenLimitMsg struct {
+	model string
+	limit int32
+}
+
+// editorDoneMsg carries the text written in $EDITOR back to the composer
+type editorDoneMsg struct {
+	text string
+}
+
+// sessionLoadedMsg replaces the conversation with one picked from /sessions
+type sessionLoadedMsg struct {
+	chatSession gemini.ChatSession
+	session     *session.Session
+}
+
+// isChatCommand reports whether input is one of the slash commands handled
+// by the chat itself rather than a message for the model
+func isChatCommand(input string) bool {
+	switch input {
+	case "/system", "/models", "/list-models", "/set", "/attach", "/sessions", "/export", "/help", "/quit":
+		return true
+	}
+	for _, prefix := range []string{"/model ", "/system ", "/set ", "/attach ", "/export "} {
+		if strings.HasPrefix(input, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionPattern matches @path file references in chat messages
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@(\S+)`)
+
+// chatParts builds the parts of a chat message: the contents of any files
+// referenced as @path, followed by the message text. Mentions that don't
+// name an existing file, directory or matching glob are left as plain text.
+// It also returns notes describing what was attached or skipped.
+func chatParts(text string) ([]genai.Part, []string, error) {
+	var mentions []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		if path, ok := mentionPath(match[1]); ok {
+			mentions = append(mentions, path)
+		}
+	}
+	if len(mentions) == 0 {
+		return []genai.Part{genai.Text(text)}, nil, nil
+	}
+
+	files, err := attach.Collect(mentions, attach.DefaultOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	notes := files.Warnings()
+	if paths := files.Paths(); len(paths) > 0 {
+		notes = append([]string{"Attached " + strings.Join(paths, ", ")}, notes...)
+	}
+	return append(files.Parts(), genai.Text(text)), notes, nil
+}
+
+// mentionPath returns the file, directory or glob named by an @mention,
+// without any punctuation ending the sentence it's in. It reports false
+// if the mention doesn't name anything, so "ping @john?" stays a question.
+func mentionPath(path string) (string, bool) {
+	if _, err := os.Stat(path); err == nil {
+		return path, true
+	}
+	path = strings.TrimRight(path, ".,?!;:")
+	if path == "" {
+		return "", false
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, true
+	}
+	if !strings.ContainsAny(path, "*?[") {
+		return "", false
+	}
+	// Only globs matching some file count, so a stray * or [ is just text
+	_, err := attach.Collect([]string{path}, attach.DefaultOptions())
+	return path, err == nil
+}
+
+// truncate shortens s to at most n runes on a single line
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/vandi/gemi/internal/session"
+)
+
+func TestChatParts(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"main.go": "package main", "notes.txt": "buy milk"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name     string
+		text     string
+		attached []string // paths of the attached files, in order
+	}{
+		{name: "no mentions", text: "hello there"},
+		{name: "file", text: "review @" + path("main.go"), attached: []string{path("main.go")}},
+		{name: "question about a file", text: "what does @" + path("main.go") + "?", attached: []string{path("main.go")}},
+		{name: "file in a sentence", text: "see @" + path("notes.txt") + ", then @" + path("main.go") + ".", attached: []string{path("notes.txt"), path("main.go")}},
+		{name: "glob", text: "summarize @" + path("*.txt"), attached: []string{path("notes.txt")}},
+		{name: "glob ending a question", text: "any bugs in @" + path("*.go") + "?", attached: []string{path("main.go")}},
+		{name: "handle ending a question", text: "ping @john?"},
+		{name: "handle ending a sentence", text: "thanks @john."},
+		{name: "glob matching nothing", text: "fix @" + path("*.rs")},
+		{name: "brackets", text: "see @todo[1]"},
+		{name: "invalid glob", text: "match @" + path("[a-") + " please"},
+		{name: "only punctuation", text: "hmm @?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, notes, err := chatParts(tt.text)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(parts) != len(tt.attached)+1 {
+				t.Fatalf("got %d parts, want %d files and the text", len(parts), len(tt.attached))
+			}
+			if text, ok := parts[len(parts)-1].(genai.Text); !ok || string(text) != tt.text {
+				t.Errorf("last part = %v, want the message text %q", parts[len(parts)-1], tt.text)
+			}
+			for i, want := range tt.attached {
+				if text, ok := parts[i].(genai.Text); !ok || !strings.HasPrefix(string(text), "File: "+want+"\n") {
+					t.Errorf("part %d = %.40q, want %s", i, parts[i], want)
+				}
+			}
+			if len(tt.attached) == 0 && len(notes) != 0 {
+				t.Errorf("notes = %q, want none", notes)
+			}
+		})
+	}
+}
+
+func TestIsChatCommand(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"/help", true},
+		{"/model gemini-1.5-flash", true},
+		{"/system", true},
+		{"/system Be brief", true},
+		{"/attach clear", true},
+		{"/quit", true},
+		{"/model", false},
+		{"/helpful tips?", false},
+		{"/usr/bin is on my PATH", false},
+		{"hello", false},
+	}
+
+	for _, tt := range tests {
+		if got := isChatCommand(tt.input); got != tt.want {
+			t.Errorf("isChatCommand(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	saved := &session.Session{Messages: []session.Message{
+		{Role: session.RoleNote, Kind: session.KindCommand, Content: "/help"},
+		{Role: session.RoleNote, Kind: session.KindOutput, Content: "Available commands"},
+		{Role: session.RoleUser, Content: "hello"},
+		{Role: session.RoleNote, Content: "Attached main.go"},
+		{Role: session.RoleModel, Content: "Hi!"},
+	}}
+	want := []messageKind{kindCommand, kindOutput, kindUser, kindNote, kindReply}
+
+	messages := transcript(saved)
+	if len(messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(messages), len(want))
+	}
+	for i, msg := range messages {
+		if msg.kind != want[i] || msg.content != saved.Messages[i].Content {
+			t.Errorf("message %d = %q of kind %d, want %q of kind %d", i, msg.content, msg.kind, saved.Messages[i].Content, want[i])
+		}
+	}
+}
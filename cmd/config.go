@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vandi/gemi/internal/config"
+	"github.com/vandi/gemi/internal/ui"
+)
+
+var (
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "View and edit configuration profiles",
+		Long: `View and edit the gemi configuration file and its named profiles.
+
+The file lives at $XDG_CONFIG_HOME/gemi/config.json (override with GEMI_CONFIG).
+The active profile is chosen by --profile, then GEMI_PROFILE, then the
+default_profile key, then "default".
+
+Settings are resolved in this order, highest first:
+  1. Command-line flags (--api-key, --model, ...)
+  2. Environment variables (GEMINI_API_KEY, GLAMOUR_STYLE)
+  3. The active profile
+  4. Built-in defaults
+
+Profile keys: ` + strings.Join(config.ProfileKeys(), ", ") + `
+
+Prices for estimating spend in gemi usage are shared by all profiles. Set
+price.MODEL to the input and output price in US dollars per million tokens;
+MODEL may be a prefix that covers several models:
+
+  gemi config set price.gemini-1.5-flash 0.075,0.30`,
+	}
+
+	configGetCmd = &cobra.Command{
+		Use:   "get KEY",
+		Short: "Print a setting of the active profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			value, err := cfg.Get(cfg.ProfileName(profile), args[0])
+			if err != nil {
+				return usageError(err)
+			}
+			fmt.Println(value)
+			return nil
+		},
+	}
+
+	configSetCmd = &cobra.Command{
+		Use:   "set KEY [VALUE]",
+		Short: "Set a setting of the active profile (omit VALUE to clear it)",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := cfg.ProfileName(profile)
+			value := ""
+			if len(args) == 2 {
+				value = args[1]
+			}
+
+			if err := cfg.Set(name, args[0], value); err != nil {
+				return usageError(err)
+			}
+			if err := cfg.Save(); err != nil {
+				return err
+			}
+
+			if args[0] == "default_profile" {
+				fmt.Println(ui.SuccessPrefix + "Default profile set to " + value)
+				return nil
+			}
+			if model, ok := strings.CutPrefix(args[0], "price."); ok {
+				fmt.Println(ui.SuccessPrefix + "Set the price of " + model)
+				return nil
+			}
+			fmt.Println(ui.SuccessPrefix + "Set " + args[0] + " in profile " + name)
+			return nil
+		},
+	}
+
+	configListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List all profiles and their settings",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := config.Path()
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("\n" + ui.RenderTitle(" Gemi Configuration ") + "\n")
+			fmt.Println(ui.InfoPrefix + "Config file: " + path)
+
+			active := cfg.ProfileName(profile)
+			names := cfg.ProfileNames()
+			if len(names) == 0 {
+				fmt.Println(ui.InfoPrefix + "No profiles defined. Create one with: gemi config set model MODEL_NAME")
+			}
+
+			for _, name := range names {
+				header := name
+				if name == active {
+					header += " (active)"
+				}
+				fmt.Println()
+				fmt.Println(ui.SubtitleStyle.Render(header))
+
+				for _, key := range config.ProfileKeys() {
+					value, _ := cfg.Get(name, key)
+					if value != "" {
+						fmt.Printf("  %s = %s\n", key, value)
+					}
+				}
+			}
+
+			if prices := cfg.PriceNames(); len(prices) > 0 {
+				fmt.Println()
+				fmt.Println(ui.SubtitleStyle.Render("Prices (USD per million input,output tokens)"))
+				for _, model := range prices {
+					value, _ := cfg.Get("", "price."+model)
+					fmt.Printf("  %s = %s\n", model, value)
+				}
+			}
+			fmt.Println()
+			return nil
+		},
+	}
+)
+
+func init() {
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configListCmd)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigSetRepairsConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string // in the rewritten file
+	}{
+		{name: "unparsable", config: `{"profiles": `, want: `"model": "gemini-1.5-flash"`},
+		{name: "invalid value", config: `{"profiles": {"default": {"temperature": 5, "system_instruction": "Be brief"}}}`, want: `"system_instruction": "Be brief"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("GEMI_CONFIG", path)
+
+			if run := runGemi(t, "", "", "config", "set", "model", "gemini-1.5-flash"); run.code != exitOK {
+				t.Fatalf("config set exited with %d: %s", run.code, run.stderr)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), tt.want) {
+				t.Errorf("config = %s, want it to contain %s", data, tt.want)
+			}
+
+			// The file stays invalid until the bad value is fixed
+			if run := runGemi(t, "", "", "config", "set", "temperature", "0.5"); run.code != exitOK {
+				t.Fatalf("config set exited with %d: %s", run.code, run.stderr)
+			}
+			if run := runGemi(t, "", "", "config", "get", "model"); run.code != exitOK || run.stdout != "gemini-1.5-flash\n" {
+				t.Errorf("config get = %q, exit code %d (stderr: %s)", run.stdout, run.code, run.stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+)
+
+// Exit codes. Scripts rely on them, so existing values must not change.
+const (
+	exitOK        = 0   // Success
+	exitError     = 1   // Any other failure
+	exitUsage     = 2   // Invalid command, flags, arguments or configuration
+	exitAuth      = 3   // Missing, invalid or unauthorized API key
+	exitQuota     = 4   // Quota exhausted or rate limited
+	exitSafety    = 5   // Prompt or response blocked by safety filters
+	exitNetwork   = 6   // Network failure, timeout or service unavailable
+	exitCancelled = 130 // Interrupted, e.g. with Ctrl+C
+)
+
+// exitCodesHelp documents the exit codes in the root command's help
+const exitCodesHelp = `Exit codes:
+  0    Success
+  1    Any other failure
+  2    Invalid command, flags, arguments or configuration
+  3    Missing, invalid or unauthorized API key
+  4    Quota exhausted or rate limited
+  5    Prompt or response blocked by safety filters
+  6    Network failure, timeout or service unavailable
+  130  Interrupted`
+
+// codedError is an error with an exit code that can't be told from the
+// error itself
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// usageError marks err as caused by how gemi was invoked
+func usageError(err error) error {
+	return &codedError{code: exitUsage, err: err}
+}
+
+// authError marks err as caused by the API key
+func authError(err error) error {
+	return &codedError{code: exitAuth, err: err}
+}
+
+// exitCode picks the exit code for the error a command failed with
+func exitCode(err error) int {
+	var coded *codedError
+	var blocked *genai.BlockedError
+	var apiErr *apierror.APIError
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.As(err, &blocked):
+		return exitSafety
+	case errors.As(err, &apiErr):
+		return apiExitCode(apiErr)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return exitNetwork
+	default:
+		return exitError
+	}
+}
+
+// apiExitCode picks the exit code for an error returned by the API
+func apiExitCode(err *apierror.APIError) int {
+	// An invalid key is reported as a bad request
+	if err.Reason() == "API_KEY_INVALID" {
+		return exitAuth
+	}
+
+	switch err.HTTPCode() {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return exitAuth
+	case http.StatusTooManyRequests:
+		return exitQuota
+	case http.StatusRequestTimeout, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return exitNetwork
+	default:
+		return exitError
+	}
+}
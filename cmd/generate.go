@@ -1,63 +1,125 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
+	"github.com/google/generative-ai-go/genai"
 	"github.com/spf13/cobra"
+	"github.com/vandi/gemi/internal/attach"
 	"github.com/vandi/gemi/internal/gemini"
 	"github.com/vandi/gemi/internal/ui"
 )
 
 var (
-	prompt        string
-	outputFile    string
-	stream        bool
-	listModelsGen bool
+	prompt          string
+	outputFile      string
+	outputMode      string
+	appendOutput    bool
+	stream          bool
+	listModelsGen   bool
+	promptSeparator string
+	contextFiles    []string
+	imageFiles      []string
+	pdfFiles        []string
+	attachFiles     []string
 
 	generateCmd = &cobra.Command{
-		Use:   "generate",
+		Use:   "generate [PROMPT...]",
 		Short: "Generate text with Gemini AI",
-		Long:  `Generate text using Gemini AI based on a prompt.`,
-		Run: func(cmd *cobra.Command, args []string) {
+		Long: `Generate text using Gemini AI based on a prompt.
+
+The prompt is built from --prompt, the positional arguments and, when stdin
+is a pipe or a file, everything read from stdin, joined in that order with
+--separator. A prompt of - reads stdin whatever it is. For example:
+
+  git diff | gemi generate "review this"
+  gemi generate -p -
+
+Files given with --file are sent ahead of the prompt, each labelled with its
+path. Directories and globs (including **) expand recursively, respecting
+.gitignore; binary and oversized files are skipped with a warning.
+
+  gemi generate --file main.go --file go.mod -p "explain"
+
+Images, PDFs and audio given with --image, --pdf or --attach are sent as
+raw bytes with a detected MIME type, up to 20 MiB in total.
+
+  gemi generate --image screenshot.png -p "what is wrong with this layout?"
+
+When stdout is not a terminal, or with --output-format text, the response is
+printed as plain text, without the prompt banner or Markdown rendering. With
+--output-format json a single JSON object is printed holding the response
+text, finish reason, safety ratings, token usage and model.
+
+With --output the response is also saved to a file, as it arrives when
+streaming. The file is only replaced once the response is complete, so a
+failed request leaves it untouched. --output-mode chooses what is saved:
+
+  raw   The response text as the model wrote it
+  ansi  The response rendered with Markdown styling
+  both  The raw text to the file, and the rendered text to FILE.ansi`,
+		RunE: func(cmd *cobra.Command, args []string) error {
 			// If --list-models flag is provided, list models and exit
 			if listModelsGen {
-				modelsCmd.Run(cmd, args)
-				return
+				return modelsCmd.RunE(cmd, args)
 			}
 
-			if prompt == "" {
-				fmt.Println(ui.ErrorPrefix + "Prompt is required. Use --prompt or -p flag.")
-				return
+			input, err := readPrompt(args)
+			if err != nil {
+				return err
 			}
 
-			apiKey, err := getApiKey()
-			if err != nil {
-				fmt.Println(ui.ErrorPrefix + err.Error())
-				return
+			// Decorations only make sense for a person reading a terminal
+			plain := plainOutput()
+
+			// Open the output file up front so a bad path fails before the
+			// request is sent
+			var output *responseOutput
+			if outputFile != "" {
+				output, err = newResponseOutput(outputFile, outputMode, appendOutput)
+				if err != nil {
+					return err
+				}
+				defer output.Discard()
 			}
 
-			client, err := gemini.NewClient(apiKey, modelName)
+			client, err := newProvider(cmd.Context(), modelName)
 			if err != nil {
-				fmt.Println(ui.ErrorPrefix + "Failed to initialize Gemini client: " + err.Error())
-				return
+				return err
 			}
 			defer client.Close()
 
-			ctx := context.Background()
+			ctx, cancel := requestContext(cmd.Context())
+			defer cancel()
+
+			if err := checkInputTokens(ctx, client, input.parts); err != nil {
+				return err
+			}
 
 			// Show prompt with Markdown formatting using Glamour
-			promptMd := "# Prompt\n\n```\n" + prompt + "\n```\n\n# Response\n"
-			formattedPrompt, err := ui.RenderMarkdownWithGlamour(promptMd)
-			if err != nil {
-				fmt.Println(ui.ErrorPrefix + "Failed to render markdown: " + err.Error())
-				fmt.Println("Prompt: " + prompt + "\n\nResponse:")
-			} else {
-				fmt.Println(formattedPrompt)
+			if !plain {
+				promptMd := "# Prompt\n\n```\n" + input.text + "\n```\n\n"
+				if paths := input.files.Paths(); len(paths) > 0 {
+					promptMd += "**Files:** `" + strings.Join(paths, "`, `") + "`\n\n"
+				}
+				for _, m := range input.media {
+					promptMd += "**Attachment:** `" + m.Describe() + "`\n\n"
+				}
+				promptMd += "# Response\n"
+				formattedPrompt, err := ui.RenderMarkdownWithGlamour(promptMd)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, ui.ErrorPrefix+"Failed to render markdown: "+err.Error())
+					fmt.Println("Prompt: " + input.text + "\n\nResponse:")
+				} else {
+					fmt.Println(formattedPrompt)
+				}
 			}
 
 			// Create a spinner
@@ -65,32 +127,63 @@ var (
 			s.Prefix = "Generating "
 			s.Color("cyan")
 
+			var resp *genai.GenerateContentResponse
 			var result string
 
 			if stream {
-				// Create a custom writer that applies Markdown formatting using Glamour
-				markdownWriter := &markdownStreamWriter{}
+				// Render finished Markdown blocks as they arrive; plain output
+				// is passed through as is, and JSON is printed at the end
+				var display io.Writer = os.Stdout
+				if jsonOutput() {
+					display = io.Discard
+				}
+				writer := ui.NewMarkdownStream(display, plain)
+
+				// Tee the raw text into the output file as it arrives
+				var sink io.Writer = writer
+				if output != nil {
+					sink = io.MultiWriter(writer, output)
+				}
 
-				if err := client.GenerateTextStream(ctx, prompt, markdownWriter); err != nil {
-					fmt.Println("\n" + ui.ErrorPrefix + "Error generating response: " + err.Error())
-					return
+				resp, err = client.GenerateTextStream(ctx, sink, input.parts...)
+				writer.Close()
+				if err != nil && ctx.Err() != nil {
+					// Keep what arrived before Ctrl+C or --timeout stopped
+					// the stream
+					if plain && !jsonOutput() {
+						fmt.Println()
+					}
+					if output != nil && output.text.Len() > 0 && output.Commit() == nil {
+						fmt.Fprintln(os.Stderr, ui.WarningPrefix+"Partial response saved to "+strings.Join(output.Paths(), " and "))
+					}
+				}
+				if err != nil {
+					return fmt.Errorf("error generating response: %w", err)
+				}
+				if plain && !jsonOutput() {
+					fmt.Println()
 				}
-				fmt.Println()
 			} else {
 				// Generate the response
-				s.Start()
-				result, err = client.GenerateText(ctx, prompt)
+				if !plain {
+					s.Start()
+				}
+				resp, err = client.GenerateText(ctx, input.parts...)
 				s.Stop()
 
 				if err != nil {
-					fmt.Println(ui.ErrorPrefix + "Error generating response: " + err.Error())
-					return
+					return fmt.Errorf("error generating response: %w", err)
 				}
+				result = gemini.ResponseText(resp)
 
-				// Print the response with Markdown formatting using Glamour
-				formattedResult, err := ui.RenderMarkdownWithGlamour(result)
-				if err != nil {
-					fmt.Println(ui.ErrorPrefix + "Failed to render markdown: " + err.Error())
+				// Print the response with Markdown formatting using Glamour.
+				// JSON is printed once the response has been saved.
+				if plain {
+					if !jsonOutput() {
+						fmt.Println(result)
+					}
+				} else if formattedResult, err := ui.RenderMarkdownWithGlamour(result); err != nil {
+					fmt.Fprintln(os.Stderr, ui.ErrorPrefix+"Failed to render markdown: "+err.Error())
 					fmt.Println(result)
 				} else {
 					fmt.Println(formattedResult)
@@ -98,52 +191,332 @@ var (
 			}
 
 			// Save to file if requested
-			if outputFile != "" && !stream {
-				if err := os.WriteFile(outputFile, []byte(result), 0644); err != nil {
-					fmt.Println(ui.ErrorPrefix + "Error saving to file: " + err.Error())
-					return
+			if output != nil {
+				if !stream {
+					io.WriteString(output, result)
+				}
+				if err := output.Commit(); err != nil {
+					return fmt.Errorf("error saving to file: %w", err)
 				}
-				fmt.Println(ui.SuccessPrefix + "Response saved to " + outputFile)
+				saved := "Response saved to " + strings.Join(output.Paths(), " and ")
+				if plain {
+					// Keep stdout clean for pipelines
+					fmt.Fprintln(os.Stderr, ui.SuccessPrefix+saved)
+				} else {
+					fmt.Println(ui.SuccessPrefix + saved)
+				}
+			}
+
+			if jsonOutput() {
+				return printJSON(newGenerateResult(modelName, resp))
 			}
+			return nil
 		},
 	}
 )
 
-// markdownStreamWriter is a custom io.Writer that applies Markdown formatting using Glamour to streamed content
-type markdownStreamWriter struct {
-	buffer strings.Builder
+// promptInput is a prompt with the files and media sent along with it
+type promptInput struct {
+	text  string
+	files *attach.Result
+	media []attach.Media
+	parts []genai.Part
+}
+
+// readPrompt builds a prompt from --prompt, the positional arguments, stdin,
+// --file and the media flags
+func readPrompt(args []string) (*promptInput, error) {
+	text, err := buildPrompt(args)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" && len(contextFiles)+len(imageFiles)+len(pdfFiles)+len(attachFiles) == 0 {
+		return nil, usageError(errors.New("prompt is required. Use --prompt or -p flag, positional arguments or stdin"))
+	}
+
+	// Inline any context files ahead of the prompt
+	files, err := attach.Collect(contextFiles, attach.DefaultOptions())
+	if err != nil {
+		return nil, usageError(err)
+	}
+	for _, warning := range files.Warnings() {
+		fmt.Fprintln(os.Stderr, ui.WarningPrefix+warning)
+	}
+
+	// Send images, PDFs and other media as raw bytes
+	media, err := loadMedia()
+	if err != nil {
+		return nil, usageError(err)
+	}
+
+	parts := append(files.Parts(), attach.MediaParts(media)...)
+	if text != "" {
+		parts = append(parts, genai.Text(text))
+	}
+	return &promptInput{text: text, files: files, media: media, parts: parts}, nil
+}
+
+// buildPrompt joins --prompt, the positional arguments and any piped stdin
+// with the configured separator. A prompt of "-" reads stdin even when it
+// isn't a pipe or file.
+func buildPrompt(args []string) (string, error) {
+	readStdin := stdinPiped()
+	var pieces []string
+	if prompt == "-" {
+		readStdin = true
+	} else if prompt != "" {
+		pieces = append(pieces, prompt)
+	}
+	var words []string
+	for _, arg := range args {
+		if arg == "-" {
+			readStdin = true
+			continue
+		}
+		words = append(words, arg)
+	}
+	if len(words) > 0 {
+		pieces = append(pieces, strings.Join(words, " "))
+	}
+
+	if readStdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read stdin: %v", err)
+		}
+		if text := strings.TrimRight(string(data), "\r\n"); strings.TrimSpace(text) != "" {
+			pieces = append(pieces, text)
+		}
+	}
+
+	// Allow escape sequences such as "\n" to be passed from the shell
+	separator := strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(promptSeparator)
+	return strings.Join(pieces, separator), nil
 }
 
-func (w *markdownStreamWriter) Write(p []byte) (n int, err error) {
-	// Convert bytes to string
-	text := string(p)
+// stdinPiped reports whether stdin is a pipe or a file. Anything else, such
+// as a terminal or a socket left open by ssh or a CI runner, might never
+// reach EOF.
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
 
-	// Apply Markdown formatting using Glamour
-	// We accumulate the text first to handle multi-line Markdown elements
-	w.buffer.WriteString(text)
+// loadMedia reads the --image, --pdf and --attach files
+func loadMedia() ([]attach.Media, error) {
+	var media []attach.Media
+	for _, group := range []struct {
+		paths []string
+		kind  string
+	}{
+		{imageFiles, attach.KindImage},
+		{pdfFiles, attach.KindPDF},
+		{attachFiles, attach.KindAny},
+	} {
+		for _, path := range group.paths {
+			m, err := attach.LoadMedia(path, group.kind)
+			if err != nil {
+				return nil, err
+			}
+			media = append(media, m)
+		}
+	}
+
+	if err := attach.CheckMediaSize(media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
 
-	// Format and print the accumulated text using Glamour
-	formatted, renderErr := ui.RenderMarkdownWithGlamour(w.buffer.String())
+// Output modes for --output-mode
+const (
+	outputRaw  = "raw"
+	outputANSI = "ansi"
+	outputBoth = "both"
+)
 
-	// Clear the terminal line and reprint the entire formatted buffer
-	// This ensures proper rendering of multi-line elements
-	fmt.Print("\r\033[K") // Clear the current line
+// responseOutput saves a response for --output as raw text, rendered ANSI
+// or both. Raw text is written as it arrives; the rendered text is written
+// once the response is complete.
+type responseOutput struct {
+	raw  *atomicFile
+	ansi *atomicFile
+	text strings.Builder
+}
 
-	if renderErr != nil {
-		// If rendering fails, just print the plain text
-		fmt.Print(w.buffer.String())
-	} else {
-		fmt.Print(formatted)
+// newResponseOutput opens the files to save a response to path in mode
+func newResponseOutput(path string, mode string, appendMode bool) (*responseOutput, error) {
+	o := &responseOutput{}
+	var err error
+	switch mode {
+	case outputRaw:
+		o.raw, err = createAtomicFile(path, appendMode)
+	case outputANSI:
+		o.ansi, err = createAtomicFile(path, appendMode)
+	case outputBoth:
+		if o.raw, err = createAtomicFile(path, appendMode); err == nil {
+			if o.ansi, err = createAtomicFile(path+".ansi", appendMode); err != nil {
+				o.raw.Discard()
+			}
+		}
+	default:
+		return nil, usageError(fmt.Errorf("unknown output mode %q (use %s, %s or %s)", mode, outputRaw, outputANSI, outputBoth))
 	}
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
 
-	// Return the number of bytes written
+// Write adds response text
+func (o *responseOutput) Write(p []byte) (int, error) {
+	o.text.Write(p)
+	if o.raw != nil {
+		return o.raw.Write(p)
+	}
 	return len(p), nil
 }
 
+// Commit renders the response if needed and replaces the target files
+func (o *responseOutput) Commit() error {
+	if o.ansi != nil {
+		rendered, err := ui.RenderMarkdownANSI(o.text.String())
+		if err != nil {
+			rendered = o.text.String()
+		}
+		if _, err := io.WriteString(o.ansi, rendered); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range []*atomicFile{o.raw, o.ansi} {
+		if f == nil {
+			continue
+		}
+		if err := f.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard drops anything not committed, leaving the target files as they were
+func (o *responseOutput) Discard() {
+	for _, f := range []*atomicFile{o.raw, o.ansi} {
+		if f != nil {
+			f.Discard()
+		}
+	}
+}
+
+// Paths returns the files the response is saved to
+func (o *responseOutput) Paths() []string {
+	var paths []string
+	for _, f := range []*atomicFile{o.raw, o.ansi} {
+		if f != nil {
+			paths = append(paths, f.path)
+		}
+	}
+	return paths
+}
+
+// atomicFile is written through a temporary file next to path, which
+// replaces path on Commit
+type atomicFile struct {
+	path string
+	tmp  *os.File
+	done bool
+}
+
+// createAtomicFile starts writing path. With appendMode the existing
+// contents are kept and written to after.
+func createAtomicFile(path string, appendMode bool) (*atomicFile, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %v", err)
+	}
+	f := &atomicFile{path: path, tmp: tmp}
+
+	// Keep the permissions of a file being replaced
+	perm := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		f.Discard()
+		return nil, fmt.Errorf("failed to create output file: %v", err)
+	}
+
+	if appendMode {
+		existing, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			f.Discard()
+			return nil, fmt.Errorf("failed to read output file: %v", err)
+		}
+
+		// Start the response on a line of its own
+		if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+			existing = append(existing, '\n')
+		}
+		if _, err := tmp.Write(existing); err != nil {
+			f.Discard()
+			return nil, fmt.Errorf("failed to write output file: %v", err)
+		}
+	}
+	return f, nil
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	n, err := f.tmp.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("failed to write output file: %v", err)
+	}
+	return n, nil
+}
+
+// Commit replaces path with what was written
+func (f *atomicFile) Commit() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	if err := f.tmp.Close(); err != nil {
+		os.Remove(f.tmp.Name())
+		return fmt.Errorf("failed to write output file: %v", err)
+	}
+	if err := os.Rename(f.tmp.Name(), f.path); err != nil {
+		os.Remove(f.tmp.Name())
+		return fmt.Errorf("failed to save output file: %v", err)
+	}
+	return nil
+}
+
+// Discard removes the temporary file unless it has been committed
+func (f *atomicFile) Discard() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
+
 func init() {
 	generateCmd.Flags().StringVarP(&prompt, "prompt", "p", "", "The prompt to send to Gemini AI")
+	generateCmd.Flags().StringVar(&promptSeparator, "separator", "\n\n", "Separator used to join --prompt, arguments and stdin")
+	generateCmd.Flags().StringArrayVarP(&contextFiles, "file", "f", nil, "File, directory or glob to include as context (repeatable)")
+	generateCmd.Flags().StringArrayVar(&imageFiles, "image", nil, "Image to send with the prompt (repeatable)")
+	generateCmd.Flags().StringArrayVar(&pdfFiles, "pdf", nil, "PDF document to send with the prompt (repeatable)")
+	generateCmd.Flags().StringArrayVar(&attachFiles, "attach", nil, "Image, PDF, audio or other media file to send with the prompt (repeatable)")
 	generateCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Save the response to a file")
+	generateCmd.Flags().StringVar(&outputMode, "output-mode", outputRaw, "What --output saves: raw, ansi (rendered Markdown) or both")
+	generateCmd.Flags().BoolVar(&appendOutput, "append", false, "Append to the --output file instead of replacing it")
 	generateCmd.Flags().BoolVarP(&stream, "stream", "s", false, "Stream the response as it's generated")
-	generateCmd.Flags().StringVar(&modelName, "model", "gemini-1.5-pro-latest", "Gemini model to use")
+	generateCmd.Flags().StringVar(&modelName, "model", "", "Gemini model to use (default from profile, or "+gemini.DefaultModel+")")
+	addGenerationFlags(generateCmd)
+	generateCmd.Flags().IntVar(&maxInputTokens, "max-input-tokens", 0, "Refuse to send a prompt of more input tokens than this")
 	generateCmd.Flags().BoolVar(&listModelsGen, "list-models", false, "List available Gemini models")
 }
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Tests run gemi as a subprocess of this binary, so every run starts
+	// with fresh flags
+	if os.Getenv("GEMI_TEST_MAIN") == "1" {
+		os.Exit(Execute())
+	}
+	os.Exit(m.Run())
+}
+
+// gemiRun is the outcome of running gemi
+type gemiRun struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// runGemi runs gemi with args against the fake backend, scripted by script
+// if it isn't empty, with its own config and data directories
+func runGemi(t *testing.T, script string, stdin string, args ...string) gemiRun {
+	t.Helper()
+	dir := t.TempDir()
+	env := append(os.Environ(),
+		"GEMI_TEST_MAIN=1",
+		"GEMI_BACKEND=fake",
+		"GEMINI_API_KEY=",
+		"GEMI_PROFILE=",
+		"GEMI_CONFIG="+os.Getenv("GEMI_CONFIG"),
+		"XDG_CONFIG_HOME="+filepath.Join(dir, "config"),
+		"XDG_DATA_HOME="+filepath.Join(dir, "data"),
+		"XDG_CACHE_HOME="+filepath.Join(dir, "cache"),
+		"GEMI_FAKE_SCRIPT=",
+	)
+	if script != "" {
+		path := filepath.Join(dir, "script.json")
+		if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		env = append(env, "GEMI_FAKE_SCRIPT="+path)
+	}
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = env
+	cmd.Dir = dir
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	run := gemiRun{}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		run.code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	run.stdout, run.stderr = stdout.String(), stderr.String()
+	return run
+}
+
+func TestGenerateFake(t *testing.T) {
+	script := `{"responses": [
+		{"match": "weather", "text": "Sunny all day."},
+		{"match": "stream", "chunks": ["one, ", "two, ", "three"]},
+		{"match": "quota", "error": "quota exhausted", "status": 429},
+		{"match": "forbidden", "error": "API key not valid", "status": 403}
+	]}`
+
+	tests := []struct {
+		name      string
+		config    string // contents of the config file, if any
+		stdin     string
+		args      []string
+		wantOut   string
+		wantErr   string
+		wantCode  int
+		checkJSON func(t *testing.T, result generateResult)
+	}{
+		{
+			name:    "echo",
+			args:    []string{"generate", "-p", "hello"},
+			wantOut: "[gemini-1.5-pro-latest] hello\n",
+		},
+		{
+			name:    "matched response",
+			args:    []string{"generate", "what's the weather?"},
+			wantOut: "Sunny all day.\n",
+		},
+		{
+			name:    "streamed chunks",
+			args:    []string{"generate", "--stream", "-p", "stream please"},
+			wantOut: "one, two, three\n",
+		},
+		{
+			name:    "prompt from stdin",
+			stdin:   "from stdin",
+			args:    []string{"generate", "--model", "test-model"},
+			wantOut: "[test-model] from stdin\n",
+		},
+		{
+			name:    "prompt of -",
+			stdin:   "from stdin",
+			args:    []string{"generate", "summarize", "-"},
+			wantOut: "[gemini-1.5-pro-latest] summarize\n\nfrom stdin\n",
+		},
+		{
+			name: "json output",
+			args: []string{"generate", "--output-format", "json", "-p", "weather"},
+			checkJSON: func(t *testing.T, result generateResult) {
+				if result.Text != "Sunny all day." || result.Model != "gemini-1.5-pro-latest" || result.Usage == nil {
+					t.Errorf("result = %+v", result)
+				}
+			},
+		},
+		{
+			name:     "quota error",
+			args:     []string{"generate", "--max-attempts", "1", "-p", "quota"},
+			wantErr:  "quota exhausted",
+			wantCode: exitQuota,
+		},
+		{
+			name:     "auth error",
+			args:     []string{"generate", "-p", "forbidden"},
+			wantErr:  "API key not valid",
+			wantCode: exitAuth,
+		},
+		{
+			name:     "no prompt",
+			args:     []string{"generate"},
+			wantCode: exitUsage,
+		},
+		{
+			name:     "over the input token limit",
+			args:     []string{"generate", "--max-input-tokens", "1", "-p", "a rather long prompt"},
+			wantErr:  "over the --max-input-tokens limit of 1",
+			wantCode: exitUsage,
+		},
+		{
+			name:     "unknown output format",
+			args:     []string{"generate", "--output-format", "yaml", "-p", "hello"},
+			wantCode: exitUsage,
+		},
+		{
+			name:     "invalid config",
+			config:   `{"profiles": `,
+			args:     []string{"generate", "-p", "hello"},
+			wantErr:  "invalid config",
+			wantCode: exitUsage,
+		},
+		{
+			name:     "invalid profile temperature",
+			config:   `{"profiles": {"hot": {"temperature": 5}}}`,
+			args:     []string{"generate", "-p", "hello"},
+			wantErr:  `profile "hot": temperature must be between 0 and 2`,
+			wantCode: exitUsage,
+		},
+		{
+			name:     "missing system file",
+			args:     []string{"generate", "--system-file", "missing.txt", "-p", "hello"},
+			wantErr:  "failed to read system instruction file",
+			wantCode: exitError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.config != "" {
+				path := filepath.Join(t.TempDir(), "config.json")
+				if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("GEMI_CONFIG", path)
+			}
+			run := runGemi(t, script, tt.stdin, tt.args...)
+			if run.code != tt.wantCode {
+				t.Fatalf("exit code %d, want %d (stderr: %s)", run.code, tt.wantCode, run.stderr)
+			}
+			if tt.wantOut != "" && run.stdout != tt.wantOut {
+				t.Errorf("stdout = %q, want %q", run.stdout, tt.wantOut)
+			}
+			if tt.wantErr != "" && !strings.Contains(run.stderr, tt.wantErr) {
+				t.Errorf("stderr = %q, want it to mention %q", run.stderr, tt.wantErr)
+			}
+			if tt.checkJSON != nil {
+				var result generateResult
+				if err := json.Unmarshal([]byte(run.stdout), &result); err != nil {
+					t.Fatalf("stdout isn't JSON: %v\n%s", err, run.stdout)
+				}
+				tt.checkJSON(t, result)
+			}
+		})
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/briandowns/spinner"
 	// "github.com/charmbracelet/lipgloss"
 	"github.com/spf13/cobra"
-	"github.com/vandi/gemi/internal/gemini"
 	"github.com/vandi/gemi/internal/ui"
 )
 
@@ -17,19 +16,16 @@ var (
 	modelsCmd = &cobra.Command{
 		Use:   "models",
 		Short: "List available Gemini models",
-		Long:  `List all available Gemini models that can be used with the chat and generate commands.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			apiKey, err := getApiKey()
+		Long: `List all available Gemini models that can be used with the chat and generate commands.
+
+With --output-format text each model is printed as its name and version
+separated by a tab, and with --output-format json the full model details are
+printed as a JSON array.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Create a client with the default model (we'll just use it to list models)
+			client, err := newProvider(cmd.Context(), modelName)
 			if err != nil {
-				fmt.Println(ui.ErrorPrefix + err.Error())
-				return
-			}
-
-			// Create a client with any model (we'll just use it to list models)
-			client, err := gemini.NewClient(apiKey, "gemini-1.5-pro-latest")
-			if err != nil {
-				fmt.Println(ui.ErrorPrefix + "Failed to initialize Gemini client: " + err.Error())
-				return
+				return err
 			}
 			defer client.Close()
 
@@ -37,15 +33,18 @@ var (
 			s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
 			s.Prefix = "Fetching available models "
 			s.Color("cyan")
-			s.Start()
+			if !plainOutput() {
+				s.Start()
+			}
 
 			// Get the list of models
-			models, err := client.ListModels()
+			ctx, cancel := requestContext(cmd.Context())
+			defer cancel()
+			models, err := client.ListModels(ctx)
 			s.Stop()
 
 			if err != nil {
-				fmt.Println(ui.ErrorPrefix + "Failed to list models: " + err.Error())
-				return
+				return err
 			}
 
 			// Sort models by name
@@ -53,6 +52,16 @@ var (
 				return models[i].Name < models[j].Name
 			})
 
+			switch {
+			case jsonOutput():
+				return printJSON(models)
+			case plainOutput():
+				for _, model := range models {
+					fmt.Printf("%s\t%s\n", strings.TrimPrefix(model.Name, "models/"), model.Version)
+				}
+				return nil
+			}
+
 			// Display the models in Markdown-friendly format
 			title := ui.RenderTitle(" Available Gemini Models ")
 			fmt.Println("\n" + title + "\n")
@@ -128,11 +137,11 @@ var (
 			// Render the Markdown using Glamour
 			renderedMarkdown, err := ui.RenderMarkdownWithGlamour(markdownOutput.String())
 			if err != nil {
-				fmt.Println(ui.ErrorPrefix + "Failed to render markdown: " + err.Error())
-				return
+				return fmt.Errorf("failed to render markdown: %w", err)
 			}
 
 			fmt.Println(renderedMarkdown)
+			return nil
 		},
 	}
 )
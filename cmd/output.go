@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/vandi/gemi/internal/session"
+	"github.com/vandi/gemi/internal/ui"
+)
+
+// Output formats for --output-format
+const (
+	formatJSON     = "json"
+	formatText     = "text"
+	formatMarkdown = "markdown"
+)
+
+// outputFormats lists the supported output formats
+var outputFormats = []string{formatJSON, formatText, formatMarkdown}
+
+// checkOutputFormat validates --output-format
+func checkOutputFormat() error {
+	switch outputFormat {
+	case "", formatJSON, formatText, formatMarkdown:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q (use %s)", outputFormat, strings.Join(outputFormats, ", "))
+	}
+}
+
+// jsonOutput reports whether output should be printed as JSON
+func jsonOutput() bool {
+	return outputFormat == formatJSON
+}
+
+// plainOutput reports whether output should be printed without banners,
+// spinners or Markdown rendering: in json and text mode, and by default
+// when stdout isn't a terminal
+func plainOutput() bool {
+	switch outputFormat {
+	case formatJSON, formatText:
+		return true
+	case formatMarkdown:
+		return false
+	default:
+		return !ui.IsTerminal(os.Stdout)
+	}
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode output: %v", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// generateResult is the JSON output of gemi generate
+type generateResult struct {
+	Model         string            `json:"model"`
+	Text          string            `json:"text"`
+	FinishReason  string            `json:"finish_reason,omitempty"`
+	SafetyRatings []safetyRating    `json:"safety_ratings,omitempty"`
+	Usage         *session.Usage    `json:"usage,omitempty"`
+	Candidates    []candidateResult `json:"candidates,omitempty"`
+}
+
+// candidateResult is one of several candidates in a generateResult
+type candidateResult struct {
+	Text          string         `json:"text"`
+	FinishReason  string         `json:"finish_reason,omitempty"`
+	SafetyRatings []safetyRating `json:"safety_ratings,omitempty"`
+}
+
+type safetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+// newGenerateResult describes a response from model. The first candidate
+// is reported at the top level; all of them are listed when there are more.
+func newGenerateResult(model string, resp *genai.GenerateContentResponse) generateResult {
+	result := generateResult{Model: model}
+	if resp == nil {
+		return result
+	}
+	result.Usage = session.UsageFrom(resp.UsageMetadata)
+
+	for _, c := range resp.Candidates {
+		candidate := candidateResult{Text: candidateText(c)}
+		if c.FinishReason != genai.FinishReasonUnspecified {
+			candidate.FinishReason = enumName(c.FinishReason.String(), "FinishReason")
+		}
+		for _, r := range c.SafetyRatings {
+			candidate.SafetyRatings = append(candidate.SafetyRatings, safetyRating{
+				Category:    enumName(r.Category.String(), ""),
+				Probability: enumName(r.Probability.String(), "HarmProbability"),
+				Blocked:     r.Blocked,
+			})
+		}
+		result.Candidates = append(result.Candidates, candidate)
+	}
+
+	if len(result.Candidates) > 0 {
+		first := result.Candidates[0]
+		result.Text, result.FinishReason, result.SafetyRatings = first.Text, first.FinishReason, first.SafetyRatings
+	}
+	if len(result.Candidates) == 1 {
+		result.Candidates = nil
+	}
+	return result
+}
+
+// candidateText joins the text parts of a candidate
+func candidateText(c *genai.Candidate) string {
+	if c.Content == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, part := range c.Content.Parts {
+		if text, ok := part.(genai.Text); ok {
+			sb.WriteString(string(text))
+		}
+	}
+	return sb.String()
+}
+
+// enumName turns the name of a genai enum value into the form the API uses,
+// e.g. FinishReasonStop into STOP, after dropping prefix
+func enumName(name string, prefix string) string {
+	name = strings.TrimPrefix(name, prefix)
+	var sb strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
@@ -1,8 +1,14 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/briandowns/spinner"
@@ -10,35 +16,302 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/vandi/gemi/internal/config"
+	"github.com/vandi/gemi/internal/gemini"
+	"github.com/vandi/gemi/internal/ui"
 )
 
 var (
-	apiKey  string
+	apiKey     string
+	backend    string
+	recordFile string
+	replayFile string
+	profile    string
+
+	// preRunStarted is set once cobra has parsed and checked the command
+	// line, so earlier errors are usage errors
+	preRunStarted bool
+
+	// commandName is the command being run, which usage is recorded under
+	commandName string
+
+	// retryPolicy is how failed requests are retried, from --max-attempts,
+	// --max-retry-time and the active profile
+	retryPolicy  = gemini.DefaultRetryPolicy
+	maxAttempts  int
+	maxRetryTime time.Duration
+
+	// rateLimit is the client-side budget per model, from --rpm, --tpm and
+	// the active profile
+	rateLimit gemini.RateLimit
+
+	// verbose reports rate limit waits. Retries and waits are only
+	// reported on stderr while notices is set.
+	verbose bool
+	notices = true
+
+	// timeout limits each request, including its retries and rate limit
+	// waits. 0 means no limit.
+	timeout time.Duration
+
+	// outputFormat is json, text, markdown or "" to pick markdown or text
+	// depending on whether stdout is a terminal
+	outputFormat string
+
+	// cfg is the loaded configuration file and activeProfile the profile
+	// selected by --profile, GEMI_PROFILE or the config's default
+	cfg           *config.Config
+	activeProfile *config.Profile
+
+	// settings are the generation settings from the active profile,
+	// overridden by any generation flags given on the command line
+	settings gemini.Settings
+
+	// Generation flag values, shared by chat and generate
+	temperature     float32
+	topP            float32
+	topK            int32
+	maxOutputTokens int32
+	candidateCount  int32
+	stopSequences   []string
+	systemText      string
+	systemFile      string
+
 	rootCmd = &cobra.Command{
 		Use:   "gemi",
 		Short: "Gemi is a beautiful CLI tool powered by Gemini AI",
 		Long: `A beautiful CLI tool built with Cobra and enhanced with various libraries
 to make it visually appealing and user-friendly. It uses the Gemini API
-to provide interactive AI capabilities.`,
+to provide interactive AI capabilities.
+
+` + exitCodesHelp,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			preRunStarted = true
+			if err := checkOutputFormat(); err != nil {
+				return usageError(err)
+			}
+			if err := loadConfig(cmd); err != nil {
+				return err
+			}
+			commandName = cmd.Name()
+			return nil
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			showWelcome()
 		},
 	}
 )
 
-// Execute executes the root command.
-func Execute() error {
-	return rootCmd.Execute()
+// Execute executes the root command, printing any error to stderr, and
+// returns the exit code.
+func Execute() int {
+	// The first Ctrl+C or SIGTERM cancels the running request, leaving the
+	// command to keep any partial output; a second one kills gemi as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	if err == nil {
+		return exitOK
+	}
+	if !preRunStarted {
+		err = usageError(err)
+	}
+	code := exitCode(err)
+
+	// The wrapped context errors say nothing a person would recognize
+	message := err.Error()
+	switch {
+	case code == exitCancelled:
+		message = "Interrupted"
+	case errors.Is(err, context.DeadlineExceeded) && timeout > 0:
+		message = fmt.Sprintf("Timed out after %s", timeout)
+	}
+
+	// Errors joined from several failures are reported one per line
+	for _, line := range strings.Split(message, "\n") {
+		fmt.Fprintln(os.Stderr, ui.ErrorPrefix+line)
+	}
+	if code == exitUsage {
+		fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", cmd.CommandPath())
+	}
+	return code
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "Gemini API key (or set GEMINI_API_KEY env var)")
+	rootCmd.PersistentFlags().StringVar(&backend, "backend", "", "Backend to use: gemini or fake (or set GEMI_BACKEND env var)")
+	rootCmd.PersistentFlags().StringVar(&recordFile, "record", "", "Record all API traffic to a cassette file")
+	rootCmd.PersistentFlags().StringVar(&replayFile, "replay", "", "Replay API traffic from a cassette file instead of calling the API")
+	rootCmd.MarkFlagsMutuallyExclusive("record", "replay")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Configuration profile to use (or set GEMI_PROFILE env var)")
+	rootCmd.PersistentFlags().IntVar(&maxAttempts, "max-attempts", 0, fmt.Sprintf("Attempts per request when it fails with a transient error such as a 429 or 503 (default from profile, or %d)", gemini.DefaultRetryPolicy.MaxAttempts))
+	rootCmd.PersistentFlags().DurationVar(&maxRetryTime, "max-retry-time", 0, fmt.Sprintf("How long to keep retrying a failing request (default from profile, or %s)", gemini.DefaultRetryPolicy.MaxElapsed))
+	rootCmd.PersistentFlags().IntVar(&rateLimit.RPM, "rpm", 0, "Most requests to send to a model per minute, shared by all gemi processes (default from profile, or unlimited)")
+	rootCmd.PersistentFlags().IntVar(&rateLimit.TPM, "tpm", 0, "Most tokens to send to a model per minute, shared by all gemi processes (default from profile, or unlimited)")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "Give up on a request after this long, including retries and rate limit waits (default no limit)")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Report time spent waiting for the rate limit")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output-format", "", "Output format for generate, models, tokens and version: json, text or markdown (default markdown on a terminal, text otherwise)")
 
 	// Add commands
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(chatCmd)
 	rootCmd.AddCommand(generateCmd)
 	rootCmd.AddCommand(modelsCmd)
+	rootCmd.AddCommand(tokensCmd)
+	rootCmd.AddCommand(usageCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(sessionsCmd)
+}
+
+// addGenerationFlags adds the generation setting flags to cmd
+func addGenerationFlags(cmd *cobra.Command) {
+	cmd.Flags().Float32Var(&temperature, "temperature", 0, "Sampling temperature, 0 to 2 (default from profile, or 0.7)")
+	cmd.Flags().Float32Var(&topP, "top-p", 0, "Nucleus sampling probability cutoff, 0 to 1")
+	cmd.Flags().Int32Var(&topK, "top-k", 0, "Number of most likely tokens to sample from")
+	cmd.Flags().Int32Var(&maxOutputTokens, "max-tokens", 0, "Maximum number of tokens in the response")
+	cmd.Flags().Int32Var(&candidateCount, "candidates", 0, "Number of responses to generate")
+	cmd.Flags().StringSliceVar(&stopSequences, "stop", nil, "Stop sequences that end generation (repeatable or comma-separated)")
+	cmd.Flags().StringVar(&systemText, "system", "", "System instruction to guide the model (default from profile)")
+	cmd.Flags().StringVar(&systemFile, "system-file", "", "Read the system instruction from a file")
+	cmd.MarkFlagsMutuallyExclusive("system", "system-file")
+}
+
+// loadConfig reads the configuration file and applies the active profile's
+// defaults to anything not set by flags. Errors in the file or the flags are
+// usage errors; failing to read a file is not.
+func loadConfig(cmd *cobra.Command) error {
+	var err error
+	cfg, err = config.Load()
+	if err != nil && cmd == configSetCmd {
+		// Let config set fix the file, or replace it if it can't be read
+		if cfg == nil {
+			fmt.Fprintln(os.Stderr, ui.WarningPrefix+err.Error()+"; starting a new config")
+			cfg = &config.Config{}
+		}
+		return nil
+	}
+	var invalid *config.InvalidError
+	if errors.As(err, &invalid) {
+		return usageError(err)
+	}
+	if err != nil {
+		return err
+	}
+	activeProfile = cfg.Profile(cfg.ProfileName(profile))
+
+	if modelName == "" {
+		modelName = activeProfile.Model
+	}
+	if modelName == "" {
+		modelName = gemini.DefaultModel
+	}
+	ui.SetStyle(activeProfile.GlamourStyle)
+
+	if err := loadRetryPolicy(cmd); err != nil {
+		return usageError(err)
+	}
+	if err := loadRateLimit(cmd); err != nil {
+		return usageError(err)
+	}
+	return loadSettings(cmd)
+}
+
+// loadRateLimit applies the active profile's rate limits to anything not
+// set by --rpm and --tpm
+func loadRateLimit(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	if !flags.Changed("rpm") && activeProfile.RPM != nil {
+		rateLimit.RPM = *activeProfile.RPM
+	}
+	if !flags.Changed("tpm") && activeProfile.TPM != nil {
+		rateLimit.TPM = *activeProfile.TPM
+	}
+	if rateLimit.RPM < 0 || rateLimit.TPM < 0 {
+		return fmt.Errorf("--rpm and --tpm can't be negative")
+	}
+	return nil
+}
+
+// loadRetryPolicy applies the retry flags, or the active profile's retry
+// settings, to the default retry policy
+func loadRetryPolicy(cmd *cobra.Command) error {
+	retryPolicy = gemini.DefaultRetryPolicy
+	if activeProfile.MaxAttempts != nil {
+		retryPolicy.MaxAttempts = *activeProfile.MaxAttempts
+	}
+	if activeProfile.MaxRetryTime != "" {
+		d, err := time.ParseDuration(activeProfile.MaxRetryTime)
+		if err != nil {
+			return fmt.Errorf("invalid max_retry_time %q in profile", activeProfile.MaxRetryTime)
+		}
+		retryPolicy.MaxElapsed = d
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("max-attempts") {
+		if maxAttempts < 1 {
+			return fmt.Errorf("--max-attempts must be at least 1")
+		}
+		retryPolicy.MaxAttempts = maxAttempts
+	}
+	if flags.Changed("max-retry-time") {
+		retryPolicy.MaxElapsed = maxRetryTime
+	}
+
+	retryPolicy.OnRetry = func(err error, attempt int, delay time.Duration) {
+		if notices {
+			fmt.Fprintf(os.Stderr, "%s%v; retrying in %s (attempt %d of %d)\n", ui.WarningPrefix, err, delay.Round(100*time.Millisecond), attempt+1, retryPolicy.MaxAttempts)
+		}
+	}
+	return nil
+}
+
+// loadSettings builds the generation settings from the active profile and
+// the generation flags that were given
+func loadSettings(cmd *cobra.Command) error {
+	settings = gemini.Settings{
+		Temperature:       activeProfile.Temperature,
+		SystemInstruction: activeProfile.SystemInstruction,
+	}
+
+	flags := cmd.Flags()
+	overrides := []struct {
+		flag string
+		key  string
+	}{
+		{"temperature", "temperature"},
+		{"top-p", "top_p"},
+		{"top-k", "top_k"},
+		{"max-tokens", "max_tokens"},
+		{"candidates", "candidates"},
+	}
+	for _, o := range overrides {
+		if flags.Lookup(o.flag) == nil || !flags.Changed(o.flag) {
+			continue
+		}
+		if err := settings.Set(o.key, flags.Lookup(o.flag).Value.String()); err != nil {
+			return usageError(err)
+		}
+	}
+	if flags.Lookup("stop") != nil && flags.Changed("stop") {
+		settings.StopSequences = stopSequences
+	}
+
+	if systemText != "" {
+		settings.SystemInstruction = systemText
+	}
+	if systemFile != "" {
+		data, err := os.ReadFile(systemFile)
+		if err != nil {
+			return fmt.Errorf("failed to read system instruction file: %v", err)
+		}
+		settings.SystemInstruction = strings.TrimSpace(string(data))
+	}
+	return nil
 }
 
 func showWelcome() {
@@ -89,25 +362,135 @@ func showWelcome() {
 	fmt.Println()
 
 	// Check for API key
-	if apiKey == "" {
-		apiKey = os.Getenv("GEMINI_API_KEY")
-		if apiKey == "" {
-			warning := color.New(color.FgYellow).SprintFunc()
-			fmt.Println(warning("⚠ ") + "No API key found. Please set your Gemini API key using:")
-			fmt.Println("  - The --api-key flag")
-			fmt.Println("  - Or the GEMINI_API_KEY environment variable")
-			fmt.Println()
-		}
+	if _, err := getApiKey(); err != nil {
+		warning := color.New(color.FgYellow).SprintFunc()
+		fmt.Println(warning("⚠ ") + "No API key found. Please set your Gemini API key using:")
+		fmt.Println("  - The --api-key flag")
+		fmt.Println("  - The GEMINI_API_KEY environment variable")
+		fmt.Println("  - Or a key source in your profile (gemi config set api_key_env NAME)")
+		fmt.Println()
 	}
 }
 
+// getApiKey resolves the API key: the --api-key flag wins over the
+// GEMINI_API_KEY env var, which wins over the active profile's key source
 func getApiKey() (string, error) {
-	key := apiKey
+	if apiKey != "" {
+		return apiKey, nil
+	}
+	if key := os.Getenv("GEMINI_API_KEY"); key != "" {
+		return key, nil
+	}
+
+	key, err := activeProfile.APIKey()
+	if err != nil {
+		return "", err
+	}
 	if key == "" {
-		key = os.Getenv("GEMINI_API_KEY")
-		if key == "" {
-			return "", fmt.Errorf("no API key provided. Use --api-key flag or set GEMINI_API_KEY environment variable")
-		}
+		return "", fmt.Errorf("no API key provided. Use --api-key flag, set GEMINI_API_KEY environment variable or configure a profile key source")
 	}
 	return key, nil
 }
+
+// requestContext returns the context for one request, which is cancelled by
+// Ctrl+C through ctx and after --timeout
+func requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// newProvider creates the backend used by the commands for the given model,
+// wrapped for recording or replaced by a replay if requested
+func newProvider(ctx context.Context, model string) (gemini.Provider, error) {
+	if replayFile != "" {
+		replayer, err := gemini.NewReplayer(replayFile)
+		if err != nil {
+			return nil, err
+		}
+		replayer.SetSettings(settings)
+		return replayer, nil
+	}
+
+	provider, err := newBackend(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+	// Only requests to the real API cost anything
+	_, billed := provider.(*gemini.Client)
+	if !rateLimit.Unlimited() {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			provider.Close()
+			return nil, fmt.Errorf("failed to locate cache directory: %v", err)
+		}
+		provider = gemini.NewLimiter(provider, model, rateLimit, filepath.Join(dir, "gemi"), func(model string, wait time.Duration) {
+			// Waits too short to notice aren't worth a line
+			if verbose && notices && wait >= 100*time.Millisecond {
+				fmt.Fprintf(os.Stderr, "%sWaiting %s for the %s rate limit\n", ui.InfoPrefix, wait.Round(100*time.Millisecond), model)
+			}
+		})
+	}
+
+	// Retry inside the recorder so only the final outcome is recorded, and
+	// outside the limiter so every attempt counts against the budget
+	provider = gemini.NewRetrier(provider, retryPolicy)
+
+	// Keep a ledger of the tokens used by every response
+	if billed {
+		provider = gemini.NewMeter(provider, model, recordUsage)
+	}
+
+	if recordFile != "" {
+		recorder, err := gemini.NewRecorder(provider, model, recordFile)
+		if err != nil {
+			provider.Close()
+			return nil, err
+		}
+		return recorder, nil
+	}
+	return provider, nil
+}
+
+// newBackend creates the backend selected by --backend or GEMI_BACKEND
+func newBackend(ctx context.Context, model string) (gemini.Provider, error) {
+	name := backend
+	if name == "" {
+		name = os.Getenv("GEMI_BACKEND")
+	}
+
+	switch name {
+	case "", "gemini":
+	case "fake":
+		return newFakeProvider(model)
+	default:
+		return nil, usageError(fmt.Errorf("unknown backend %q (expected gemini or fake)", name))
+	}
+
+	apiKey, err := getApiKey()
+	if err != nil {
+		return nil, authError(err)
+	}
+
+	client, err := gemini.NewClient(ctx, apiKey, model, settings)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize Gemini client: %w", err)
+	}
+	return client, nil
+}
+
+// newFakeProvider creates the offline backend, scripted by GEMI_FAKE_SCRIPT if set
+func newFakeProvider(model string) (gemini.Provider, error) {
+	var script *gemini.FakeScript
+	if path := os.Getenv("GEMI_FAKE_SCRIPT"); path != "" {
+		var err error
+		script, err = gemini.LoadFakeScript(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fake := gemini.NewFakeProvider(script, model)
+	fake.SetSettings(settings)
+	return fake, nil
+}
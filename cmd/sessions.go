@@ -0,0 +1,353 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/spf13/cobra"
+	"github.com/vandi/gemi/internal/session"
+	"github.com/vandi/gemi/internal/ui"
+)
+
+var (
+	pruneOlderThan string
+	exportFormat   string
+	exportOutput   string
+
+	sessionsCmd = &cobra.Command{
+		Use:   "sessions",
+		Short: "Manage saved chat sessions",
+		Long: `List, show, rename, delete, prune and search the chat sessions saved by gemi chat.
+
+Sessions can be referred to by full ID, a unique ID prefix, or "last" for the
+most recently active one.`,
+	}
+
+	sessionsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List saved sessions",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sessions, err := session.List()
+			if err != nil {
+				return err
+			}
+			if len(sessions) == 0 {
+				fmt.Println(ui.InfoPrefix + "No saved sessions. Start one with: gemi chat")
+				return nil
+			}
+
+			t := table.New().
+				Border(lipgloss.RoundedBorder()).
+				BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color(ui.SecondaryColor))).
+				StyleFunc(func(row, col int) lipgloss.Style {
+					if row == table.HeaderRow {
+						return ui.SubtitleStyle.Padding(0, 1)
+					}
+					return lipgloss.NewStyle().Padding(0, 1)
+				}).
+				Headers("ID", "TITLE", "MODEL", "MESSAGES", "LAST ACTIVITY")
+
+			for _, s := range sessions {
+				t.Row(s.ID, truncate(s.Title, 40), s.Model, strconv.Itoa(s.MessageCount()), humanizeAge(s.UpdatedAt))
+			}
+			fmt.Println(t)
+			return nil
+		},
+	}
+
+	sessionsShowCmd = &cobra.Command{
+		Use:   "show ID",
+		Short: "Show the transcript of a session",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := session.Resolve(args[0])
+			if err != nil {
+				return usageError(err)
+			}
+
+			fmt.Println("\n" + ui.RenderTitle(" "+s.Title+" ") + "\n")
+			fmt.Println(ui.InfoPrefix + "Model: " + s.Model + "  ·  Started " + s.CreatedAt.Local().Format("2006-01-02 15:04") + "  ·  Last active " + humanizeAge(s.UpdatedAt))
+			if s.SystemInstruction != "" {
+				fmt.Println(ui.InfoPrefix + "System: " + s.SystemInstruction)
+			}
+
+			rendered, err := ui.RenderMarkdownWithGlamour(s.Markdown())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, ui.ErrorPrefix+"Failed to render markdown: "+err.Error())
+				fmt.Println(s.Markdown())
+				return nil
+			}
+			fmt.Println(rendered)
+			return nil
+		},
+	}
+
+	sessionsRenameCmd = &cobra.Command{
+		Use:   "rename ID TITLE",
+		Short: "Rename a session",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := session.Resolve(args[0])
+			if err != nil {
+				return usageError(err)
+			}
+
+			s.Title = strings.Join(args[1:], " ")
+			if err := session.Save(s); err != nil {
+				return err
+			}
+			fmt.Println(ui.SuccessPrefix + "Renamed " + s.ID + " to " + s.Title)
+			return nil
+		},
+	}
+
+	sessionsDeleteCmd = &cobra.Command{
+		Use:   "delete ID...",
+		Short: "Delete sessions",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Delete what can be deleted, then report the rest
+			var failed []error
+			for _, ref := range args {
+				s, err := session.Resolve(ref)
+				if err != nil {
+					failed = append(failed, usageError(err))
+					continue
+				}
+				if err := session.Delete(s.ID); err != nil {
+					failed = append(failed, err)
+					continue
+				}
+				fmt.Println(ui.SuccessPrefix + "Deleted " + s.ID + " (" + s.Title + ")")
+			}
+			return errors.Join(failed...)
+		},
+	}
+
+	sessionsExportCmd = &cobra.Command{
+		Use:   "export ID",
+		Short: "Export a session as Markdown, JSON, HTML or JSONL",
+		Long: `Export a session to a file, or to stdout without --output.
+
+Formats:
+  md     The "You:"/"Gemini:" transcript layout
+  json   The full session with roles, parts, model, token usage and timestamps
+  html   A self-contained page with syntax-highlighted code
+  jsonl  The conversation as a Gemini fine-tuning example, one per line
+
+Without --format, the format is chosen from the --output extension, or md.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s, err := session.Resolve(args[0])
+			if err != nil {
+				return usageError(err)
+			}
+
+			format := exportFormat
+			if format == "" {
+				format = session.FormatFromPath(exportOutput)
+			}
+
+			if exportOutput == "" {
+				data, err := session.Export(s, format)
+				if err != nil {
+					return usageError(err)
+				}
+				os.Stdout.Write(data)
+				return nil
+			}
+
+			if err := exportSession(s, exportOutput, format); err != nil {
+				return err
+			}
+			fmt.Println(ui.SuccessPrefix + "Exported " + s.ID + " to " + exportOutput)
+			return nil
+		},
+	}
+
+	sessionsPruneCmd = &cobra.Command{
+		Use:   "prune",
+		Short: "Delete sessions inactive for longer than --older-than",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			age, err := parseAge(pruneOlderThan)
+			if err != nil {
+				return usageError(err)
+			}
+
+			pruned, err := session.Prune(time.Now().Add(-age))
+			for _, s := range pruned {
+				fmt.Println(ui.SuccessPrefix + "Deleted " + s.ID + " (" + s.Title + ")")
+			}
+			if len(pruned) == 0 && err == nil {
+				fmt.Println(ui.InfoPrefix + "No sessions older than " + pruneOlderThan)
+			}
+			return err
+		},
+	}
+
+	sessionsSearchCmd = &cobra.Command{
+		Use:   "search QUERY",
+		Short: "Search the transcripts of all sessions",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := strings.Join(args, " ")
+			matches, err := session.Search(query)
+			if err != nil {
+				return err
+			}
+			if len(matches) == 0 {
+				fmt.Println(ui.InfoPrefix + "No messages match " + strconv.Quote(query))
+				return nil
+			}
+
+			printMatches(os.Stdout, matches, query)
+			return nil
+		},
+	}
+)
+
+func init() {
+	sessionsExportCmd.Flags().StringVar(&exportFormat, "format", "", "Export format: "+strings.Join(session.Formats, ", "))
+	sessionsExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "File to write the export to (default stdout)")
+	sessionsPruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "30d", "Delete sessions last active longer ago than this (e.g. 12h, 30d, 8w)")
+
+	sessionsCmd.AddCommand(sessionsListCmd)
+	sessionsCmd.AddCommand(sessionsShowCmd)
+	sessionsCmd.AddCommand(sessionsRenameCmd)
+	sessionsCmd.AddCommand(sessionsDeleteCmd)
+	sessionsCmd.AddCommand(sessionsExportCmd)
+	sessionsCmd.AddCommand(sessionsPruneCmd)
+	sessionsCmd.AddCommand(sessionsSearchCmd)
+}
+
+// exportSession writes a session to path in format
+func exportSession(s *session.Session, path string, format string) error {
+	data, err := session.Export(s, format)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write export: %v", err)
+	}
+	return nil
+}
+
+// printMatches prints search matches grouped by session, each labelled
+// with who wrote it
+func printMatches(w io.Writer, matches []session.Match, query string) {
+	var current *session.Session
+	for _, match := range matches {
+		if match.Session != current {
+			current = match.Session
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, ui.SubtitleStyle.Render(current.ID)+"  "+current.Title)
+		}
+
+		var who string
+		switch match.Message.Role {
+		case session.RoleUser:
+			who = "You"
+		case session.RoleModel:
+			who = "Gemini"
+		case session.RoleNote:
+			who = "Note"
+		default:
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %s\n", who, highlightMatch(snippet(match.Message.Content, query, 80), query))
+	}
+	fmt.Fprintln(w)
+}
+
+// parseAge parses a duration that may also use d (days) and w (weeks)
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q (use e.g. 12h, 30d or 8w)", s)
+	}
+	return d, nil
+}
+
+// humanizeAge describes how long ago t was
+func humanizeAge(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	case d < 30*24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	default:
+		return t.Local().Format("2006-01-02")
+	}
+}
+
+// snippet returns about width runes of text around the first match of query
+func snippet(text string, query string, width int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+
+	// Lowercasing maps rune for rune but can change the byte length, so the
+	// match is located by its rune offset in the lowered text
+	lower := strings.ToLower(text)
+	idx := strings.Index(lower, strings.ToLower(query))
+	start := 0
+	if idx > 0 {
+		start = max(0, utf8.RuneCountInString(lower[:idx])-width/3)
+	}
+	end := min(len(runes), start+width)
+
+	out := string(runes[start:end])
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(runes) {
+		out += "…"
+	}
+	return out
+}
+
+// highlightMatch emphasizes every case-insensitive occurrence of query
+func highlightMatch(text string, query string) string {
+	lower := strings.ToLower(text)
+	needle := strings.ToLower(query)
+	if needle == "" || len(lower) != len(text) {
+		return text
+	}
+
+	var sb strings.Builder
+	for {
+		idx := strings.Index(lower, needle)
+		if idx < 0 {
+			sb.WriteString(text)
+			return sb.String()
+		}
+		sb.WriteString(text[:idx])
+		sb.WriteString(ui.WarningText(text[idx : idx+len(needle)]))
+		text, lower = text[idx+len(needle):], lower[idx+len(needle):]
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vandi/gemi/internal/session"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "90m", want: 90 * time.Minute},
+		{value: "12h", want: 12 * time.Hour},
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "7d", want: 7 * 24 * time.Hour},
+		{value: "1.5d", want: 36 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "0d"},
+		{value: "", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "-3d", wantErr: true},
+		{value: "-1h", wantErr: true},
+		{value: "3 days", wantErr: true},
+		{value: "12", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseAge(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseAge(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseAge(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		width int
+		want  string
+	}{
+		{name: "short text", text: "find  the\nneedle", query: "needle", width: 20, want: "find the needle"},
+		{name: "match near the start", text: "needle " + strings.Repeat("x", 30), query: "needle", width: 10, want: "needle xxx…"},
+		{name: "match further in", text: strings.Repeat("a", 30) + " needle " + strings.Repeat("b", 30), query: "NEEDLE", width: 12, want: "…aaa needle b…"},
+		{name: "no match", text: strings.Repeat("a", 30), query: "needle", width: 5, want: "aaaaa…"},
+		{name: "lowercasing changes the byte length", text: strings.Repeat("Ⱥ", 100) + " needle", query: "needle", width: 12, want: "…ȺȺȺ needle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippet(tt.text, tt.query, tt.width); got != tt.want {
+				t.Errorf("snippet = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintMatches(t *testing.T) {
+	s := &session.Session{ID: "20240101-120000", Title: "Deploys"}
+	matches := []session.Match{
+		{Session: s, Message: session.Message{Role: session.RoleUser, Content: "how do I deploy?"}},
+		{Session: s, Message: session.Message{Role: session.RoleModel, Content: "Run make deploy."}},
+		{Session: s, Message: session.Message{Role: session.RoleNote, Content: "Switched model to deploy-bot"}},
+	}
+
+	var out strings.Builder
+	printMatches(&out, matches, "deploy")
+	for _, want := range []string{
+		"  You: how do I deploy?\n",
+		"  Gemini: Run make deploy.\n",
+		"  Note: Switched model to deploy-bot\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+	"github.com/vandi/gemi/internal/gemini"
+	"github.com/vandi/gemi/internal/ui"
+)
+
+var (
+	// maxInputTokens refuses generate requests and chat messages whose
+	// input would be larger. 0 means no limit.
+	maxInputTokens int
+
+	tokensCmd = &cobra.Command{
+		Use:   "tokens [PROMPT...]",
+		Short: "Count the input tokens of a prompt",
+		Long: `Count the input tokens a prompt takes with a model, without sending it.
+
+The prompt is built the same way as for generate, from --prompt, the
+positional arguments, stdin, --file and the media flags. The system
+instruction is counted too.
+
+  gemi tokens --file ./internal -p "review this package"
+
+With --output-format text only the count is printed, and with
+--output-format json the count is printed along with the model and its
+input token limit.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input, err := readPrompt(args)
+			if err != nil {
+				return err
+			}
+
+			client, err := newProvider(cmd.Context(), modelName)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			ctx, cancel := requestContext(cmd.Context())
+			defer cancel()
+
+			tokens, err := client.CountTokens(ctx, input.parts...)
+			if err != nil {
+				return err
+			}
+			if plainOutput() && !jsonOutput() {
+				fmt.Println(tokens)
+				return nil
+			}
+
+			info, err := client.ModelInfo(ctx)
+			if err != nil {
+				return err
+			}
+			if jsonOutput() {
+				return printJSON(tokensResult{Model: modelName, Tokens: tokens, InputTokenLimit: info.InputTokenLimit})
+			}
+
+			if info.InputTokenLimit > 0 {
+				fmt.Printf("%s%d input tokens for %s, %s of its %d token limit\n", ui.InfoPrefix, tokens, modelName, percent(tokens, info.InputTokenLimit), info.InputTokenLimit)
+			} else {
+				fmt.Printf("%s%d input tokens for %s\n", ui.InfoPrefix, tokens, modelName)
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	tokensCmd.Flags().StringVarP(&prompt, "prompt", "p", "", "The prompt to count")
+	tokensCmd.Flags().StringVar(&promptSeparator, "separator", "\n\n", "Separator used to join --prompt, arguments and stdin")
+	tokensCmd.Flags().StringArrayVarP(&contextFiles, "file", "f", nil, "File, directory or glob to include as context (repeatable)")
+	tokensCmd.Flags().StringArrayVar(&imageFiles, "image", nil, "Image to count with the prompt (repeatable)")
+	tokensCmd.Flags().StringArrayVar(&pdfFiles, "pdf", nil, "PDF document to count with the prompt (repeatable)")
+	tokensCmd.Flags().StringArrayVar(&attachFiles, "attach", nil, "Image, PDF, audio or other media file to count with the prompt (repeatable)")
+	tokensCmd.Flags().StringVar(&modelName, "model", "", "Gemini model to count for (default from profile, or "+gemini.DefaultModel+")")
+	tokensCmd.Flags().StringVar(&systemText, "system", "", "System instruction to count with the prompt (default from profile)")
+	tokensCmd.Flags().StringVar(&systemFile, "system-file", "", "Read the system instruction from a file")
+	tokensCmd.MarkFlagsMutuallyExclusive("system", "system-file")
+}
+
+// tokensResult is the JSON output of gemi tokens
+type tokensResult struct {
+	Model           string `json:"model"`
+	Tokens          int32  `json:"tokens"`
+	InputTokenLimit int32  `json:"input_token_limit,omitempty"`
+}
+
+// tokenCounter counts the input tokens of a request; both Provider and
+// ChatSession are one
+type tokenCounter interface {
+	CountTokens(ctx context.Context, parts ...genai.Part) (int32, error)
+}
+
+// checkInputTokens refuses a request whose input would be over
+// --max-input-tokens. Nothing is counted when there's no limit.
+func checkInputTokens(ctx context.Context, counter tokenCounter, parts []genai.Part) error {
+	if maxInputTokens <= 0 {
+		return nil
+	}
+	tokens, err := counter.CountTokens(ctx, parts...)
+	if err != nil {
+		return err
+	}
+	if int(tokens) > maxInputTokens {
+		return usageError(fmt.Errorf("request is %d input tokens, over the --max-input-tokens limit of %d", tokens, maxInputTokens))
+	}
+	return nil
+}
+
+// percent formats part as a percentage of whole
+func percent(part int32, whole int32) string {
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(whole))
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/google/generative-ai-go/genai"
+	"github.com/spf13/cobra"
+	"github.com/vandi/gemi/internal/ui"
+	"github.com/vandi/gemi/internal/usage"
+)
+
+var (
+	usageSince string
+	usageBy    string
+
+	usageCmd = &cobra.Command{
+		Use:   "usage",
+		Short: "Summarize the tokens used by gemi",
+		Long: `Summarize the tokens used by gemi, from the ledger every generate and chat
+request is recorded in.
+
+The ledger lives at $XDG_DATA_HOME/gemi/usage.jsonl and holds the prompt,
+response and total token counts of each request, with its model, command
+and time. Requests to the fake backend and replayed requests aren't
+recorded.
+
+Spend is estimated from the prices set with gemi config set price.MODEL;
+requests for models without a price are counted but not costed.
+
+  gemi usage --since 7d --by day`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var since time.Time
+			if usageSince != "" {
+				age, err := parseAge(usageSince)
+				if err != nil {
+					return usageError(err)
+				}
+				since = time.Now().Add(-age)
+			}
+
+			entries, err := usage.Load(since)
+			if err != nil {
+				return err
+			}
+			groups, total, err := usage.Summarize(entries, usageBy, func(e usage.Entry) (float64, bool) {
+				price, ok := cfg.PriceFor(e.Model)
+				return price.Cost(int64(e.PromptTokens), int64(e.CandidatesTokens)), ok
+			})
+			if err != nil {
+				return usageError(err)
+			}
+
+			switch {
+			case jsonOutput():
+				return printJSON(usageResult{By: usageBy, Since: usageSince, Groups: groups, Total: total})
+			case plainOutput():
+				for _, t := range groups {
+					fmt.Printf("%s\t%d\t%d\t%d\t%d\t%.4f\n", t.Key, t.Requests, t.PromptTokens, t.CandidatesTokens, t.TotalTokens, t.Cost)
+				}
+				return nil
+			}
+
+			if len(groups) == 0 {
+				fmt.Println(ui.InfoPrefix + "No usage recorded yet")
+				return nil
+			}
+			printUsageTable(groups, total)
+			return nil
+		},
+	}
+)
+
+func init() {
+	usageCmd.Flags().StringVar(&usageSince, "since", "", "Only count requests this recent, e.g. 12h, 7d or 4w (default all)")
+	usageCmd.Flags().StringVar(&usageBy, "by", usage.ByModel, "Group requests by model, day or command")
+}
+
+// usageResult is the JSON output of gemi usage
+type usageResult struct {
+	By     string        `json:"by"`
+	Since  string        `json:"since,omitempty"`
+	Groups []usage.Total `json:"groups"`
+	Total  usage.Total   `json:"total"`
+}
+
+// printUsageTable prints the usage of each group and the total as a table,
+// with estimated spend if any prices are set
+func printUsageTable(groups []usage.Total, total usage.Total) {
+	priced := len(cfg.Prices) > 0
+	headers := []string{strings.ToUpper(usageBy), "REQUESTS", "PROMPT", "RESPONSE", "TOTAL"}
+	if priced {
+		headers = append(headers, "EST. COST")
+	}
+
+	row := func(t usage.Total, key string) []string {
+		cells := []string{key, strconv.Itoa(t.Requests), strconv.FormatInt(t.PromptTokens, 10), strconv.FormatInt(t.CandidatesTokens, 10), strconv.FormatInt(t.TotalTokens, 10)}
+		if priced {
+			cost := formatCost(t.Cost)
+			if t.Unpriced > 0 {
+				cost += "*"
+			}
+			cells = append(cells, cost)
+		}
+		return cells
+	}
+
+	t := table.New().
+		Border(lipgloss.RoundedBorder()).
+		BorderStyle(lipgloss.NewStyle().Foreground(lipgloss.Color(ui.SecondaryColor))).
+		StyleFunc(func(r, col int) lipgloss.Style {
+			style := lipgloss.NewStyle().Padding(0, 1)
+			if r == table.HeaderRow || r == len(groups) {
+				style = ui.SubtitleStyle.Padding(0, 1)
+			}
+			if col > 0 {
+				style = style.Align(lipgloss.Right)
+			}
+			return style
+		}).
+		Headers(headers...)
+	for _, g := range groups {
+		t.Row(row(g, g.Key)...)
+	}
+	t.Row(row(total, "Total")...)
+	fmt.Println(t)
+
+	if priced && total.Unpriced > 0 {
+		requests := fmt.Sprintf("%d requests are", total.Unpriced)
+		if total.Unpriced == 1 {
+			requests = "1 request is"
+		}
+		fmt.Printf("* %s for models without a price (set one with: gemi config set price.MODEL INPUT,OUTPUT)\n", requests)
+	}
+}
+
+// formatCost formats an amount of US dollars, with more decimals for
+// amounts under a cent so small spend doesn't show as nothing
+func formatCost(cost float64) string {
+	if cost > 0 && cost < 0.01 {
+		return fmt.Sprintf("$%.4f", cost)
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
+
+// recordUsage adds the usage of a response to the ledger. A ledger that
+// can't be written never fails the request itself.
+func recordUsage(model string, metadata *genai.UsageMetadata) {
+	err := usage.Append(usage.Entry{
+		Time:             time.Now(),
+		Command:          commandName,
+		Model:            model,
+		PromptTokens:     metadata.PromptTokenCount,
+		CandidatesTokens: metadata.CandidatesTokenCount,
+		TotalTokens:      metadata.TotalTokenCount,
+	})
+	if err != nil && notices {
+		fmt.Fprintln(os.Stderr, ui.WarningPrefix+err.Error())
+	}
+}
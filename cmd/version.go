@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+	"runtime"
+	"runtime/debug"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/spf13/cobra"
@@ -11,24 +13,75 @@ const (
 	Version = "1.0.0"
 )
 
+// Commit is the git commit gemi was built from. It is read from the build
+// info unless set with -ldflags "-X github.com/vandi/gemi/cmd.Commit=...".
+var Commit string
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Display version information",
-	Long:  `Display the current version of the Gemi CLI tool.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Display the current version of the Gemi CLI tool, the commit it was built
+from and the Go version used to build it.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		commit := buildCommit()
+		switch {
+		case jsonOutput():
+			return printJSON(map[string]string{
+				"version":    Version,
+				"commit":     commit,
+				"go_version": runtime.Version(),
+			})
+		case plainOutput():
+			fmt.Printf("gemi %s (commit %s, %s)\n", Version, commit, runtime.Version())
+			return nil
+		}
+
 		// Create a styled version display
 		versionStyle := lipgloss.NewStyle().
 			Bold(true).
 			Foreground(lipgloss.Color("#5F9EF3"))
-		
+
 		boxStyle := lipgloss.NewStyle().
 			Border(lipgloss.RoundedBorder()).
 			BorderForeground(lipgloss.Color("#5F9EF3")).
 			Padding(1, 3).
 			MarginTop(1).
 			MarginBottom(1)
-		
+
 		versionInfo := fmt.Sprintf("Gemi CLI version %s", Version)
-		fmt.Println(boxStyle.Render(versionStyle.Render(versionInfo)))
+		buildInfo := fmt.Sprintf("commit %s · %s", commit, runtime.Version())
+		fmt.Println(boxStyle.Render(versionStyle.Render(versionInfo) + "\n" + buildInfo))
+		return nil
 	},
 }
+
+// buildCommit returns Commit, or the revision recorded in the build info
+func buildCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "unknown"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}
@@ -0,0 +1,322 @@
+package attach
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const (
+	// DefaultMaxFileSize is the largest single file that is inlined
+	DefaultMaxFileSize = 512 * 1024
+
+	// DefaultMaxTotalSize caps the combined size of all inlined files
+	DefaultMaxTotalSize = 2 * 1024 * 1024
+)
+
+// Options control which files Collect includes
+type Options struct {
+	// MaxFileSize skips files larger than this many bytes
+	MaxFileSize int64
+
+	// MaxTotalSize skips files once the combined size would exceed it
+	MaxTotalSize int64
+}
+
+// DefaultOptions returns the default size limits
+func DefaultOptions() Options {
+	return Options{
+		MaxFileSize:  DefaultMaxFileSize,
+		MaxTotalSize: DefaultMaxTotalSize,
+	}
+}
+
+// File is a text file to send as context
+type File struct {
+	Path     string
+	Language string
+	Content  []byte
+}
+
+// Skipped is a file that was left out, and why
+type Skipped struct {
+	Path   string
+	Reason string
+}
+
+// Result is the outcome of collecting files
+type Result struct {
+	Files   []File
+	Skipped []Skipped
+}
+
+// Parts returns one labelled text part per file
+func (r *Result) Parts() []genai.Part {
+	parts := make([]genai.Part, 0, len(r.Files))
+	for _, f := range r.Files {
+		parts = append(parts, f.Part())
+	}
+	return parts
+}
+
+// Paths returns the paths of the collected files
+func (r *Result) Paths() []string {
+	paths := make([]string, 0, len(r.Files))
+	for _, f := range r.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+// Warnings describes the skipped files
+func (r *Result) Warnings() []string {
+	warnings := make([]string, 0, len(r.Skipped))
+	for _, s := range r.Skipped {
+		warnings = append(warnings, "Skipped "+s.Path+": "+s.Reason)
+	}
+	return warnings
+}
+
+// Part formats the file as a text part labelled with its path, with the
+// contents in a fenced code block
+func (f File) Part() genai.Part {
+	// Use a fence longer than any backtick run in the file
+	fence := "```"
+	for bytes.Contains(f.Content, []byte(fence)) {
+		fence += "`"
+	}
+
+	content := strings.TrimRight(string(f.Content), "\n")
+	return genai.Text(fmt.Sprintf("File: %s\n%s%s\n%s\n%s", f.Path, fence, f.Language, content, fence))
+}
+
+// Collect reads the files named by patterns. A pattern may be a file, a
+// directory (read recursively) or a glob, where ** matches any number of
+// directories. Directories and globs respect .gitignore; files named
+// explicitly are always included. A pattern that matches nothing is an error.
+func Collect(patterns []string, opts Options) (*Result, error) {
+	c := &collector{opts: opts, seen: make(map[string]bool), result: &Result{}}
+
+	for _, pattern := range patterns {
+		if err := c.collect(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return c.result, nil
+}
+
+// collector accumulates files across patterns
+type collector struct {
+	opts   Options
+	seen   map[string]bool
+	total  int64
+	result *Result
+}
+
+func (c *collector) collect(pattern string) error {
+	if !hasMeta(pattern) {
+		info, err := os.Stat(pattern)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", pattern, err)
+		}
+		if info.IsDir() {
+			return c.walk(pattern, nil)
+		}
+		c.add(pattern, info)
+		return nil
+	}
+
+	pattern = filepath.Clean(pattern)
+	re, err := globToRegexp(filepath.ToSlash(pattern))
+	if err != nil {
+		return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+	}
+
+	root := globRoot(pattern)
+	if _, err := os.Stat(root); err != nil {
+		return fmt.Errorf("no files match %s", pattern)
+	}
+
+	before := len(c.result.Files) + len(c.result.Skipped)
+	if err := c.walk(root, func(path string) bool {
+		return re.MatchString(filepath.ToSlash(path))
+	}); err != nil {
+		return err
+	}
+	if len(c.result.Files)+len(c.result.Skipped) == before {
+		return fmt.Errorf("no files match %s", pattern)
+	}
+	return nil
+}
+
+// walk adds the files under root accepted by match (all files if nil),
+// skipping anything ignored by .gitignore
+func (c *collector) walk(root string, match func(path string) bool) error {
+	ignores := newIgnoreList(root)
+
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != root && (d.Name() == ".git" || ignores.ignored(path, true)) {
+				return filepath.SkipDir
+			}
+			ignores.load(path)
+			return nil
+		}
+
+		if ignores.ignored(path, false) || (match != nil && !match(path)) {
+			return nil
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", root, err)
+	}
+
+	sort.Strings(paths)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		c.add(path, info)
+	}
+	return nil
+}
+
+// add reads a file, or records why it was skipped
+func (c *collector) add(path string, info os.FileInfo) {
+	path = filepath.Clean(path)
+	if c.seen[path] {
+		return
+	}
+	c.seen[path] = true
+
+	skip := func(reason string) {
+		c.result.Skipped = append(c.result.Skipped, Skipped{Path: path, Reason: reason})
+	}
+
+	if c.opts.MaxFileSize > 0 && info.Size() > c.opts.MaxFileSize {
+		skip(fmt.Sprintf("larger than %s", FormatSize(c.opts.MaxFileSize)))
+		return
+	}
+	if c.opts.MaxTotalSize > 0 && c.total+info.Size() > c.opts.MaxTotalSize {
+		skip(fmt.Sprintf("total size limit of %s reached", FormatSize(c.opts.MaxTotalSize)))
+		return
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		skip(err.Error())
+		return
+	}
+	if isBinary(content) {
+		skip("binary file")
+		return
+	}
+
+	c.total += int64(len(content))
+	c.result.Files = append(c.result.Files, File{
+		Path:     path,
+		Language: Language(path),
+		Content:  content,
+	})
+}
+
+// hasMeta reports whether path contains glob metacharacters
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// globRoot returns the directory a glob should be walked from: its longest
+// leading part without metacharacters
+func globRoot(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for hasMeta(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// isBinary reports whether content looks like binary data
+func isBinary(content []byte) bool {
+	head := content
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// FormatSize formats a byte count for messages
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KiB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// languages maps file extensions to Markdown fence languages
+var languages = map[string]string{
+	".go":    "go",
+	".mod":   "go",
+	".py":    "python",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".jsx":   "jsx",
+	".ts":    "typescript",
+	".tsx":   "tsx",
+	".rs":    "rust",
+	".rb":    "ruby",
+	".java":  "java",
+	".kt":    "kotlin",
+	".swift": "swift",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".php":   "php",
+	".sh":    "bash",
+	".bash":  "bash",
+	".zsh":   "zsh",
+	".sql":   "sql",
+	".html":  "html",
+	".css":   "css",
+	".scss":  "scss",
+	".json":  "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".xml":   "xml",
+	".md":    "markdown",
+	".proto": "protobuf",
+	".lua":   "lua",
+	".tf":    "hcl",
+}
+
+// Language guesses the fence language of a file from its name
+func Language(path string) string {
+	switch strings.ToLower(filepath.Base(path)) {
+	case "dockerfile":
+		return "dockerfile"
+	case "makefile":
+		return "makefile"
+	}
+	return languages[strings.ToLower(filepath.Ext(path))]
+}
@@ -0,0 +1,175 @@
+package attach
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is a single pattern from a .gitignore file
+type ignoreRule struct {
+	base     string // directory holding the .gitignore
+	pattern  *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool // pattern contains a slash, so it matches the path from base
+}
+
+// ignoreList holds the .gitignore rules that apply during a walk. Later rules
+// override earlier ones, as in git.
+type ignoreList struct {
+	rules  []ignoreRule
+	loaded map[string]bool
+}
+
+// newIgnoreList loads the .gitignore files of dir and its ancestors up to
+// the enclosing git repository root
+func newIgnoreList(dir string) *ignoreList {
+	l := &ignoreList{loaded: make(map[string]bool)}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return l
+	}
+
+	// Collect ancestors up to the repository root, then load outermost first
+	var dirs []string
+	for d := abs; ; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			break
+		}
+		if filepath.Dir(d) == d {
+			// Not inside a repository: only the directory itself applies
+			dirs = dirs[:1]
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		l.load(dirs[i])
+	}
+	return l
+}
+
+// load reads the .gitignore file in dir, if any
+func (l *ignoreList) load(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil || l.loaded[abs] {
+		return
+	}
+	l.loaded[abs] = true
+
+	f, err := os.Open(filepath.Join(abs, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: abs}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		re, err := globToRegexp(line)
+		if err != nil {
+			continue
+		}
+		rule.pattern = re
+		l.rules = append(l.rules, rule)
+	}
+}
+
+// ignored reports whether path is excluded by the loaded rules
+func (l *ignoreList) ignored(path string, isDir bool) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	ignored := false
+	for _, rule := range l.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		rel, err := filepath.Rel(rule.base, abs)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+
+		subject := rel
+		if !rule.anchored {
+			subject = filepath.Base(abs)
+		}
+		if rule.pattern.MatchString(subject) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// globToRegexp converts a glob with *, ?, [...] and ** into an anchored
+// regular expression over slash-separated paths
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			sb.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
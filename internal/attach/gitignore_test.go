@@ -0,0 +1,104 @@
+package attach
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"*.go", "main.gox", false},
+		{"main.?o", "main.go", true},
+		{"main.?o", "main.o", false},
+		{"?", "/", false},
+		{"file[0-9].txt", "file7.txt", true},
+		{"file[0-9].txt", "fileA.txt", false},
+		{"file[!0-9].txt", "fileA.txt", true},
+		{"file[!0-9].txt", "file7.txt", false},
+		{"a[b", "a[b", true},
+		{"**/testdata", "testdata", true},
+		{"**/testdata", "a/b/testdata", true},
+		{"**/testdata", "a/testdata/x", false},
+		{"build/**", "build/out/app", true},
+		{"build/**", "build", true},
+		{"build/**", "builder", false},
+		{"docs/**/*.md", "docs/a/b/readme.md", true},
+		{"docs/**/*.md", "docs/readme.md", true},
+		{"a**b", "a/x/b", true},
+		{"v1.0", "v1.0", true},
+		{"v1.0", "v1x0", false},
+		{"(x)+", "(x)+", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			re, err := globToRegexp(tt.glob)
+			if err != nil {
+				t.Fatalf("globToRegexp(%q): %v", tt.glob, err)
+			}
+			if got := re.MatchString(tt.path); got != tt.match {
+				t.Errorf("%q matching %q = %v, want %v (regexp %s)", tt.glob, tt.path, got, tt.match, re)
+			}
+		})
+	}
+}
+
+func TestIgnoreList(t *testing.T) {
+	root := t.TempDir()
+	write := func(path string, content string) {
+		t.Helper()
+		path = filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write(".gitignore", "# build output\n*.log\n!keep.log\nbin/\n/top.txt\ndocs/*.tmp\n\\#hash\n")
+	write("pkg/.gitignore", "generated.go\n!*.log\n")
+
+	// Starting in pkg loads its rules after those of the repository root
+	list := newIgnoreList(filepath.Join(root, "pkg"))
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{path: "debug.log", ignored: true},
+		{path: "src/debug.log", ignored: true},
+		{path: "keep.log"},
+		{path: "pkg/debug.log"},
+		{path: "bin", isDir: true, ignored: true},
+		{path: "src/bin", isDir: true, ignored: true},
+		{path: "bin"},
+		{path: "top.txt", ignored: true},
+		{path: "src/top.txt"},
+		{path: "docs/a.tmp", ignored: true},
+		{path: "docs/sub/a.tmp"},
+		{path: "#hash", ignored: true},
+		{path: "pkg/generated.go", ignored: true},
+		{path: "generated.go"},
+		{path: "main.go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path := filepath.Join(root, filepath.FromSlash(tt.path))
+			if got := list.ignored(path, tt.isDir); got != tt.ignored {
+				t.Errorf("ignored(%q, dir=%v) = %v, want %v", tt.path, tt.isDir, got, tt.ignored)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package attach
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// MaxMediaSize is the largest combined size of media sent inline in one
+// request; the API rejects larger inline payloads
+const MaxMediaSize = 20 * 1024 * 1024
+
+// Media kinds accepted by LoadMedia
+const (
+	KindAny   = ""
+	KindImage = "image"
+	KindPDF   = "pdf"
+)
+
+// supportedMedia are the MIME type prefixes the API accepts inline
+var supportedMedia = []string{"image/", "audio/", "video/", "application/pdf", "text/"}
+
+// Media is an image, document or audio file sent as raw bytes
+type Media struct {
+	Path     string
+	MIMEType string
+	Data     []byte
+}
+
+// LoadMedia reads a media file and detects its MIME type. kind restricts
+// the accepted types to images or PDFs.
+func LoadMedia(path string, kind string) (Media, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Media{}, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if info.IsDir() {
+		return Media{}, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > MaxMediaSize {
+		return Media{}, fmt.Errorf("%s is %s, larger than the %s limit", path, FormatSize(info.Size()), FormatSize(MaxMediaSize))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Media{}, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	m := Media{Path: path, MIMEType: DetectMIMEType(path, data), Data: data}
+	switch {
+	case kind == KindImage && !strings.HasPrefix(m.MIMEType, "image/"):
+		return Media{}, fmt.Errorf("%s is %s, not an image", path, m.MIMEType)
+	case kind == KindPDF && m.MIMEType != "application/pdf":
+		return Media{}, fmt.Errorf("%s is %s, not a PDF", path, m.MIMEType)
+	}
+
+	for _, prefix := range supportedMedia {
+		if strings.HasPrefix(m.MIMEType, prefix) {
+			return m, nil
+		}
+	}
+	return Media{}, fmt.Errorf("%s has unsupported type %s", path, m.MIMEType)
+}
+
+// DetectMIMEType guesses the MIME type of a file from its extension,
+// falling back to sniffing its contents
+func DetectMIMEType(path string, data []byte) string {
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return mediaType
+	}
+	return mimeType
+}
+
+// CheckMediaSize returns an error if media together exceed MaxMediaSize
+func CheckMediaSize(media []Media) error {
+	var total int64
+	for _, m := range media {
+		total += int64(len(m.Data))
+	}
+	if total > MaxMediaSize {
+		return fmt.Errorf("attachments total %s, larger than the %s limit", FormatSize(total), FormatSize(MaxMediaSize))
+	}
+	return nil
+}
+
+// Part returns the media as an image or blob part
+func (m Media) Part() genai.Part {
+	if format, ok := strings.CutPrefix(m.MIMEType, "image/"); ok {
+		return genai.ImageData(format, m.Data)
+	}
+	return genai.Blob{MIMEType: m.MIMEType, Data: m.Data}
+}
+
+// Describe summarizes the media for messages
+func (m Media) Describe() string {
+	return fmt.Sprintf("%s (%s, %s)", m.Path, m.MIMEType, FormatSize(int64(len(m.Data))))
+}
+
+// MediaParts returns one part per media file
+func MediaParts(media []Media) []genai.Part {
+	parts := make([]genai.Part, 0, len(media))
+	for _, m := range media {
+		parts = append(parts, m.Part())
+	}
+	return parts
+}
@@ -0,0 +1,420 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultProfile is the profile used when none is selected
+const DefaultProfile = "default"
+
+// Config is the contents of the gemi configuration file
+type Config struct {
+	// DefaultProfile is the profile used when --profile and GEMI_PROFILE are unset
+	DefaultProfile string `json:"default_profile,omitempty"`
+
+	// Profiles holds the named profiles
+	Profiles map[string]*Profile `json:"profiles,omitempty"`
+
+	// Prices holds the price of each model, keyed by model name or a
+	// prefix of it such as "gemini-1.5-flash", for estimating spend
+	Prices map[string]Price `json:"prices,omitempty"`
+}
+
+// Price is what a model charges in US dollars per million tokens
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// Cost estimates what a request with the given token counts costs
+func (p Price) Cost(promptTokens int64, candidatesTokens int64) float64 {
+	return (float64(promptTokens)*p.Input + float64(candidatesTokens)*p.Output) / 1e6
+}
+
+// pricePrefix starts the keys that set Prices, such as price.gemini-1.5-pro
+const pricePrefix = "price."
+
+// Profile is a named set of defaults
+type Profile struct {
+	// APIKeyEnv names an environment variable holding the API key
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+
+	// APIKeyFile is a file whose contents are the API key
+	APIKeyFile string `json:"api_key_file,omitempty"`
+
+	// Model is the default model
+	Model string `json:"model,omitempty"`
+
+	// Temperature is the default sampling temperature
+	Temperature *float32 `json:"temperature,omitempty"`
+
+	// SystemInstruction is sent with every request
+	SystemInstruction string `json:"system_instruction,omitempty"`
+
+	// GlamourStyle is the glamour style name or path used to render markdown
+	GlamourStyle string `json:"glamour_style,omitempty"`
+
+	// MaxAttempts is the number of attempts made per request when it fails
+	// with a transient error
+	MaxAttempts *int `json:"max_attempts,omitempty"`
+
+	// MaxRetryTime is how long a failing request is retried for, such as "2m"
+	MaxRetryTime string `json:"max_retry_time,omitempty"`
+
+	// RPM and TPM cap the requests and tokens sent per minute to each model
+	RPM *int `json:"rpm,omitempty"`
+	TPM *int `json:"tpm,omitempty"`
+}
+
+// Path returns the location of the configuration file: $GEMI_CONFIG if set,
+// otherwise gemi/config.json under the XDG config directory
+func Path() (string, error) {
+	if path := os.Getenv("GEMI_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate config directory: %v", err)
+	}
+	return filepath.Join(dir, "gemi", "config.json"), nil
+}
+
+// InvalidError is a config file that can't be used as written, as opposed
+// to one that couldn't be read
+type InvalidError struct {
+	Path string
+	Err  error
+}
+
+func (e *InvalidError) Error() string {
+	return fmt.Sprintf("invalid config %s: %v", e.Path, e.Err)
+}
+
+func (e *InvalidError) Unwrap() error {
+	return e.Err
+}
+
+// Load reads the configuration file. A missing file yields an empty Config.
+// A file holding invalid values yields its Config along with an
+// *InvalidError, so that the values can be corrected.
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, &InvalidError{Path: path, Err: err}
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, &InvalidError{Path: path, Err: err}
+	}
+	return cfg, nil
+}
+
+// validate checks every profile's values as if each had been set by Set
+func (c *Config) validate() error {
+	for _, name := range c.ProfileNames() {
+		p := c.Profile(name)
+		for _, key := range fieldOrder {
+			if err := fields[key].set(&Profile{}, fields[key].get(p)); err != nil {
+				return fmt.Errorf("profile %q: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Save writes the configuration file, creating its directory if needed
+func (c *Config) Save() error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	return nil
+}
+
+// ProfileName resolves the active profile name: the given name (from
+// --profile) wins over GEMI_PROFILE, which wins over DefaultProfile
+func (c *Config) ProfileName(name string) string {
+	if name != "" {
+		return name
+	}
+	if env := os.Getenv("GEMI_PROFILE"); env != "" {
+		return env
+	}
+	if c.DefaultProfile != "" {
+		return c.DefaultProfile
+	}
+	return DefaultProfile
+}
+
+// Profile returns the named profile, or an empty one if it doesn't exist
+func (c *Config) Profile(name string) *Profile {
+	if p, ok := c.Profiles[name]; ok && p != nil {
+		return p
+	}
+	return &Profile{}
+}
+
+// ProfileNames returns the sorted profile names
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PriceFor returns the price of model from the entry for the longest prefix
+// of its name, if any
+func (c *Config) PriceFor(model string) (Price, bool) {
+	var price Price
+	found := ""
+	for name, p := range c.Prices {
+		if strings.HasPrefix(model, name) && len(name) >= len(found) {
+			price, found = p, name
+		}
+	}
+	return price, found != ""
+}
+
+// PriceNames returns the sorted model names in the price table
+func (c *Config) PriceNames() []string {
+	names := make([]string, 0, len(c.Prices))
+	for name := range c.Prices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the value of a profile key as a string
+func (c *Config) Get(profile string, key string) (string, error) {
+	if key == "default_profile" {
+		return c.DefaultProfile, nil
+	}
+	if model, ok := strings.CutPrefix(key, pricePrefix); ok && model != "" {
+		p, ok := c.Prices[model]
+		if !ok {
+			return "", nil
+		}
+		return strconv.FormatFloat(p.Input, 'f', -1, 64) + "," + strconv.FormatFloat(p.Output, 'f', -1, 64), nil
+	}
+
+	field, ok := fields[key]
+	if !ok {
+		return "", unknownKeyError(key)
+	}
+	return field.get(c.Profile(profile)), nil
+}
+
+// Set sets a profile key, creating the profile if needed. An empty value
+// clears the key.
+func (c *Config) Set(profile string, key string, value string) error {
+	if key == "default_profile" {
+		c.DefaultProfile = value
+		return nil
+	}
+	if model, ok := strings.CutPrefix(key, pricePrefix); ok && model != "" {
+		return c.setPrice(model, value)
+	}
+
+	field, ok := fields[key]
+	if !ok {
+		return unknownKeyError(key)
+	}
+
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]*Profile)
+	}
+	p, ok := c.Profiles[profile]
+	if !ok || p == nil {
+		p = &Profile{}
+		c.Profiles[profile] = p
+	}
+	return field.set(p, value)
+}
+
+// setPrice sets the price of a model from "INPUT,OUTPUT" in US dollars per
+// million tokens. An empty value removes it.
+func (c *Config) setPrice(model string, value string) error {
+	if value == "" {
+		delete(c.Prices, model)
+		return nil
+	}
+
+	input, output, ok := strings.Cut(value, ",")
+	in, inErr := strconv.ParseFloat(strings.TrimSpace(input), 64)
+	out, outErr := strconv.ParseFloat(strings.TrimSpace(output), 64)
+	if !ok || inErr != nil || outErr != nil || in < 0 || out < 0 {
+		return fmt.Errorf("invalid price %q: must be INPUT,OUTPUT in US dollars per million tokens, e.g. 0.075,0.30", value)
+	}
+
+	if c.Prices == nil {
+		c.Prices = make(map[string]Price)
+	}
+	c.Prices[model] = Price{Input: in, Output: out}
+	return nil
+}
+
+// Keys returns the settable keys in display order
+func Keys() []string {
+	return append([]string{"default_profile", pricePrefix + "MODEL"}, fieldOrder...)
+}
+
+// ProfileKeys returns the keys stored in a profile, in display order
+func ProfileKeys() []string {
+	return fieldOrder
+}
+
+// APIKey returns the key from the profile's key source, or "" if it has none
+func (p *Profile) APIKey() (string, error) {
+	if p.APIKeyEnv != "" {
+		if key := os.Getenv(p.APIKeyEnv); key != "" {
+			return key, nil
+		}
+	}
+	if p.APIKeyFile != "" {
+		data, err := os.ReadFile(expandHome(p.APIKeyFile))
+		if err != nil {
+			return "", fmt.Errorf("failed to read API key file: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", nil
+}
+
+// field reads and writes one profile key
+type field struct {
+	get func(p *Profile) string
+	set func(p *Profile, value string) error
+}
+
+var fieldOrder = []string{"api_key_env", "api_key_file", "model", "temperature", "system_instruction", "glamour_style", "max_attempts", "max_retry_time", "rpm", "tpm"}
+
+var fields = map[string]field{
+	"api_key_env":        stringField(func(p *Profile) *string { return &p.APIKeyEnv }),
+	"api_key_file":       stringField(func(p *Profile) *string { return &p.APIKeyFile }),
+	"model":              stringField(func(p *Profile) *string { return &p.Model }),
+	"system_instruction": stringField(func(p *Profile) *string { return &p.SystemInstruction }),
+	"glamour_style":      stringField(func(p *Profile) *string { return &p.GlamourStyle }),
+	"temperature": {
+		get: func(p *Profile) string {
+			if p.Temperature == nil {
+				return ""
+			}
+			return strconv.FormatFloat(float64(*p.Temperature), 'g', -1, 32)
+		},
+		set: func(p *Profile, value string) error {
+			if value == "" {
+				p.Temperature = nil
+				return nil
+			}
+			t, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				return fmt.Errorf("invalid temperature %q: %v", value, err)
+			}
+			if t < 0 || t > 2 {
+				return fmt.Errorf("temperature must be between 0 and 2")
+			}
+			f := float32(t)
+			p.Temperature = &f
+			return nil
+		},
+	},
+	"max_attempts": intField("max_attempts", 1, func(p *Profile) **int { return &p.MaxAttempts }),
+	"rpm":          intField("rpm", 0, func(p *Profile) **int { return &p.RPM }),
+	"tpm":          intField("tpm", 0, func(p *Profile) **int { return &p.TPM }),
+	"max_retry_time": {
+		get: func(p *Profile) string { return p.MaxRetryTime },
+		set: func(p *Profile, value string) error {
+			if value != "" {
+				if d, err := time.ParseDuration(value); err != nil || d < 0 {
+					return fmt.Errorf("invalid max_retry_time %q: use a duration such as 90s or 2m", value)
+				}
+			}
+			p.MaxRetryTime = value
+			return nil
+		},
+	},
+}
+
+func stringField(ptr func(p *Profile) *string) field {
+	return field{
+		get: func(p *Profile) string { return *ptr(p) },
+		set: func(p *Profile, value string) error {
+			*ptr(p) = value
+			return nil
+		},
+	}
+}
+
+// intField is a whole number key of at least min
+func intField(key string, min int, ptr func(p *Profile) **int) field {
+	return field{
+		get: func(p *Profile) string {
+			if *ptr(p) == nil {
+				return ""
+			}
+			return strconv.Itoa(**ptr(p))
+		},
+		set: func(p *Profile, value string) error {
+			if value == "" {
+				*ptr(p) = nil
+				return nil
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < min {
+				return fmt.Errorf("invalid %s %q: must be a whole number of at least %d", key, value, min)
+			}
+			*ptr(p) = &n
+			return nil
+		},
+	}
+}
+
+func unknownKeyError(key string) error {
+	return fmt.Errorf("unknown config key %q (valid keys: %s)", key, strings.Join(Keys(), ", "))
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
@@ -0,0 +1,473 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+)
+
+// Cassette methods identify which Provider call an Interaction records
+const (
+	MethodGenerate       = "generate"
+	MethodGenerateStream = "generate_stream"
+	MethodChat           = "chat"
+	MethodChatStream     = "chat_stream"
+	MethodListModels     = "list_models"
+)
+
+// Kinds of failed call an Interaction records, so replay fails the same way
+const (
+	KindBlocked          = "blocked"
+	KindCanceled         = "canceled"
+	KindDeadlineExceeded = "deadline_exceeded"
+)
+
+// Cassette is a recording of the traffic between gemi and a Provider
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a single recorded request and its response
+type Interaction struct {
+	Method string `json:"method"`
+	Model  string `json:"model,omitempty"`
+
+	// Request is the text of the prompt or chat message
+	Request string `json:"request,omitempty"`
+
+	// Response is the full response text
+	Response string `json:"response,omitempty"`
+
+	// Chunks are the streamed pieces of Response, in order
+	Chunks []string `json:"chunks,omitempty"`
+
+	// Usage is the token usage reported for the response
+	Usage *genai.UsageMetadata `json:"usage,omitempty"`
+
+	// Models is the result of a ListModels call
+	Models []*genai.ModelInfo `json:"models,omitempty"`
+
+	// Error is the message of a failed call
+	Error string `json:"error,omitempty"`
+
+	// Status is the HTTP status of a failed call, if the API sent one
+	Status int `json:"status,omitempty"`
+
+	// Kind is the kind of a failed call that wasn't an API error, if known
+	Kind string `json:"kind,omitempty"`
+
+	// Blocked is why a call of KindBlocked was blocked
+	Blocked *genai.BlockedError `json:"blocked,omitempty"`
+}
+
+// response rebuilds the recorded response
+func (in Interaction) response() *genai.GenerateContentResponse {
+	resp := textResponse(in.Response)
+	resp.UsageMetadata = in.Usage
+	return resp
+}
+
+// failure rebuilds the recorded error as the same kind of error
+func (in Interaction) failure() error {
+	switch in.Kind {
+	case KindBlocked:
+		blocked := in.Blocked
+		if blocked == nil {
+			blocked = &genai.BlockedError{}
+		}
+		return &replayedError{message: in.Error, err: blocked}
+	case KindCanceled:
+		return &replayedError{message: in.Error, err: context.Canceled}
+	case KindDeadlineExceeded:
+		return &replayedError{message: in.Error, err: context.DeadlineExceeded}
+	}
+	return fakeError(FakeResponse{Error: in.Error, Status: in.Status})
+}
+
+// replayedError is a recorded error message wrapping the kind of error it was
+type replayedError struct {
+	message string
+	err     error
+}
+
+func (e *replayedError) Error() string {
+	return e.message
+}
+
+func (e *replayedError) Unwrap() error {
+	return e.err
+}
+
+// LoadCassette reads a cassette file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %v", err)
+	}
+
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %v", path, err)
+	}
+	return &cassette, nil
+}
+
+// Save writes the cassette to path, replacing it atomically
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cassette-*")
+	if err != nil {
+		return fmt.Errorf("failed to save cassette: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save cassette: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save cassette: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save cassette: %v", err)
+	}
+	return nil
+}
+
+// Recorder is a Provider that passes every call through to another Provider
+// and saves the traffic to a cassette file
+type Recorder struct {
+	mu       sync.Mutex
+	provider Provider
+	path     string
+	model    string
+	cassette Cassette
+}
+
+// NewRecorder wraps provider, recording to path. The file is created
+// immediately and rewritten after every call.
+func NewRecorder(provider Provider, modelName string, path string) (*Recorder, error) {
+	r := &Recorder{
+		provider: provider,
+		path:     path,
+		model:    modelName,
+		cassette: Cassette{Interactions: []Interaction{}},
+	}
+	if err := r.cassette.Save(path); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// record appends an interaction and saves the cassette
+func (r *Recorder) record(in Interaction, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	in.Model = r.model
+	if err != nil {
+		in.Error = err.Error()
+		// Keep failures replayable as the same kind of error
+		var blocked *genai.BlockedError
+		switch {
+		case errors.As(err, &blocked):
+			in.Kind, in.Blocked = KindBlocked, blocked
+		case errors.Is(err, context.Canceled):
+			in.Kind = KindCanceled
+		case errors.Is(err, context.DeadlineExceeded):
+			in.Kind = KindDeadlineExceeded
+		}
+		var apiErr *apierror.APIError
+		if errors.As(err, &apiErr) && apiErr.HTTPCode() > 0 {
+			in.Status = apiErr.HTTPCode()
+			var httpErr *googleapi.Error
+			if errors.As(err, &httpErr) {
+				in.Error = httpErr.Message
+			}
+		}
+	}
+	r.cassette.Interactions = append(r.cassette.Interactions, in)
+	return r.cassette.Save(r.path)
+}
+
+// Close closes the wrapped provider
+func (r *Recorder) Close() error {
+	return r.provider.Close()
+}
+
+// GenerateText generates a response and records the exchange
+func (r *Recorder) GenerateText(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := r.provider.GenerateText(ctx, parts...)
+	in := Interaction{Method: MethodGenerate, Request: partsToString(parts)}
+	if resp != nil {
+		in.Response = responseToString(resp)
+		in.Usage = resp.UsageMetadata
+	}
+	if saveErr := r.record(in, err); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return resp, err
+}
+
+// GenerateTextStream streams a response and records every chunk in order
+func (r *Recorder) GenerateTextStream(ctx context.Context, writer io.Writer, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	tee := &chunkRecorder{writer: writer}
+	resp, err := r.provider.GenerateTextStream(ctx, tee, parts...)
+
+	in := Interaction{Method: MethodGenerateStream, Request: partsToString(parts), Chunks: tee.chunks}
+	for _, chunk := range tee.chunks {
+		in.Response += chunk
+	}
+	if resp != nil {
+		in.Usage = resp.UsageMetadata
+	}
+	if saveErr := r.record(in, err); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return resp, err
+}
+
+// StartChat starts a chat session whose messages are recorded
+func (r *Recorder) StartChat() ChatSession {
+	return &recordingChatSession{ChatSession: r.provider.StartChat(), recorder: r}
+}
+
+// CountTokens counts tokens without recording; replays estimate them
+func (r *Recorder) CountTokens(ctx context.Context, parts ...genai.Part) (int32, error) {
+	return r.provider.CountTokens(ctx, parts...)
+}
+
+// ModelInfo describes the current model without recording; replays don't
+// know model limits
+func (r *Recorder) ModelInfo(ctx context.Context) (*genai.ModelInfo, error) {
+	return r.provider.ModelInfo(ctx)
+}
+
+// ListModels lists models and records the result
+func (r *Recorder) ListModels(ctx context.Context) ([]*genai.ModelInfo, error) {
+	models, err := r.provider.ListModels(ctx)
+	if saveErr := r.record(Interaction{Method: MethodListModels, Models: models}, err); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return models, err
+}
+
+// SwitchModel switches the wrapped provider to a different model
+func (r *Recorder) SwitchModel(modelName string) error {
+	if err := r.provider.SwitchModel(modelName); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.model = modelName
+	r.mu.Unlock()
+	return nil
+}
+
+// Settings returns the wrapped provider's generation settings
+func (r *Recorder) Settings() Settings {
+	return r.provider.Settings()
+}
+
+// SetSettings replaces the wrapped provider's generation settings
+func (r *Recorder) SetSettings(settings Settings) error {
+	return r.provider.SetSettings(settings)
+}
+
+// chunkRecorder remembers every write passed through to writer
+type chunkRecorder struct {
+	writer io.Writer
+	chunks []string
+}
+
+func (w *chunkRecorder) Write(p []byte) (int, error) {
+	w.chunks = append(w.chunks, string(p))
+	return w.writer.Write(p)
+}
+
+// recordingChatSession records the messages of a wrapped chat session
+type recordingChatSession struct {
+	ChatSession
+	recorder *Recorder
+}
+
+// SendMessage sends a message and records the exchange
+func (s *recordingChatSession) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := s.ChatSession.SendMessage(ctx, parts...)
+	in := Interaction{Method: MethodChat, Request: partsToString(parts), Response: responseToString(resp)}
+	if resp != nil {
+		in.Usage = resp.UsageMetadata
+	}
+	if saveErr := s.recorder.record(in, err); saveErr != nil && err == nil {
+		return nil, saveErr
+	}
+	return resp, err
+}
+
+// SendMessageStream sends a message through the wrapped session and records
+// the streamed chunks
+func (s *recordingChatSession) SendMessageStream(ctx context.Context, onChunk func(text string), parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	var chunks []string
+	resp, err := s.ChatSession.SendMessageStream(ctx, func(text string) {
+		chunks = append(chunks, text)
+		onChunk(text)
+	}, parts...)
+
+	in := Interaction{Method: MethodChatStream, Request: partsToString(parts), Chunks: chunks, Response: strings.Join(chunks, "")}
+	if resp != nil {
+		in.Usage = resp.UsageMetadata
+	}
+	if saveErr := s.recorder.record(in, err); saveErr != nil && err == nil {
+		return nil, saveErr
+	}
+	return resp, err
+}
+
+// Replayer is a Provider that serves the interactions of a cassette in
+// order, without any network access
+type Replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	settings Settings
+	next     int
+}
+
+// NewReplayer creates a Provider replaying the cassette at path
+func NewReplayer(path string) (*Replayer, error) {
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{cassette: cassette}, nil
+}
+
+// replay returns the next interaction, checking that it matches the call
+func (r *Replayer) replay(method string, request string) (Interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next >= len(r.cassette.Interactions) {
+		return Interaction{}, fmt.Errorf("replay: no recorded interaction left for %s %q", method, request)
+	}
+
+	in := r.cassette.Interactions[r.next]
+	if in.Method != method || in.Request != request {
+		return Interaction{}, fmt.Errorf("replay: interaction %d is %s %q, got %s %q", r.next+1, in.Method, in.Request, method, request)
+	}
+	r.next++
+
+	if in.Error != "" {
+		return in, in.failure()
+	}
+	return in, nil
+}
+
+// Close is a no-op
+func (r *Replayer) Close() error {
+	return nil
+}
+
+// GenerateText replays a recorded generation
+func (r *Replayer) GenerateText(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	in, err := r.replay(MethodGenerate, partsToString(parts))
+	if err != nil {
+		return nil, err
+	}
+	return in.response(), nil
+}
+
+// GenerateTextStream replays the recorded chunks of a streamed generation
+func (r *Replayer) GenerateTextStream(ctx context.Context, writer io.Writer, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	in, err := r.replay(MethodGenerateStream, partsToString(parts))
+	for _, chunk := range in.Chunks {
+		if _, werr := fmt.Fprint(writer, chunk); werr != nil {
+			return nil, fmt.Errorf("failed to write response: %w", werr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return in.response(), nil
+}
+
+// StartChat starts a chat session answered from the cassette
+func (r *Replayer) StartChat() ChatSession {
+	return &localChatSession{
+		send: func(ctx context.Context, prompt string, onChunk func(string)) (*genai.GenerateContentResponse, error) {
+			if onChunk == nil {
+				in, err := r.replay(MethodChat, prompt)
+				if err != nil {
+					return nil, err
+				}
+				return in.response(), nil
+			}
+
+			in, err := r.replay(MethodChatStream, prompt)
+			for _, chunk := range in.Chunks {
+				onChunk(chunk)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return in.response(), nil
+		},
+	}
+}
+
+// CountTokens estimates the input tokens of a prompt, since token counts
+// aren't recorded
+func (r *Replayer) CountTokens(ctx context.Context, parts ...genai.Part) (int32, error) {
+	return estimateTokens(partsToString(parts)), nil
+}
+
+// ModelInfo returns details without token limits, since model details
+// aren't recorded
+func (r *Replayer) ModelInfo(ctx context.Context) (*genai.ModelInfo, error) {
+	return &genai.ModelInfo{}, nil
+}
+
+// ListModels replays a recorded model listing
+func (r *Replayer) ListModels(ctx context.Context) ([]*genai.ModelInfo, error) {
+	in, err := r.replay(MethodListModels, "")
+	if err != nil {
+		return nil, err
+	}
+	return in.Models, nil
+}
+
+// SwitchModel is accepted without checks; the cassette decides the responses
+func (r *Replayer) SwitchModel(modelName string) error {
+	if modelName == "" {
+		return fmt.Errorf("model name cannot be empty")
+	}
+	return nil
+}
+
+// Settings returns the current generation settings
+func (r *Replayer) Settings() Settings {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.settings
+}
+
+// SetSettings replaces the generation settings. Replay ignores them.
+func (r *Replayer) SetSettings(settings Settings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settings = settings
+	return nil
+}
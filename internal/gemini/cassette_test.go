@@ -0,0 +1,215 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+)
+
+// call is a Provider call made the same way while recording and replaying
+type call struct {
+	name string
+	run  func(p Provider, chat ChatSession) (string, error)
+}
+
+func TestRecordReplay(t *testing.T) {
+	script := &FakeScript{
+		Models: []FakeModel{{Name: "models/test-model", BaseModelID: "test-model", Version: "001"}},
+		Responses: []FakeResponse{
+			{Match: "quota", Error: "quota exhausted", Status: 429},
+			{Match: "broken", Error: "plain failure"},
+			{Text: "generated"},
+			{Chunks: []string{"stre", "amed"}},
+			{Text: "chat reply"},
+			{Chunks: []string{"chat ", "stream"}},
+		},
+	}
+
+	calls := []call{
+		{"generate", func(p Provider, _ ChatSession) (string, error) {
+			resp, err := p.GenerateText(context.Background(), genai.Text("one"))
+			if err != nil {
+				return "", err
+			}
+			return ResponseText(resp), nil
+		}},
+		{"generate stream", func(p Provider, _ ChatSession) (string, error) {
+			var sb strings.Builder
+			_, err := p.GenerateTextStream(context.Background(), &sb, genai.Text("two"))
+			return sb.String(), err
+		}},
+		{"chat", func(_ Provider, chat ChatSession) (string, error) {
+			resp, err := chat.SendMessage(context.Background(), genai.Text("three"))
+			if err != nil {
+				return "", err
+			}
+			return ResponseText(resp), nil
+		}},
+		{"chat stream", func(_ Provider, chat ChatSession) (string, error) {
+			var chunks []string
+			_, err := chat.SendMessageStream(context.Background(), func(text string) {
+				chunks = append(chunks, text)
+			}, genai.Text("four"))
+			return strings.Join(chunks, "|"), err
+		}},
+		{"list models", func(p Provider, _ ChatSession) (string, error) {
+			models, err := p.ListModels(context.Background())
+			if err != nil {
+				return "", err
+			}
+			var names []string
+			for _, m := range models {
+				names = append(names, m.Name)
+			}
+			return strings.Join(names, ","), nil
+		}},
+		{"api error", func(p Provider, _ ChatSession) (string, error) {
+			_, err := p.GenerateText(context.Background(), genai.Text("quota"))
+			return "", err
+		}},
+		{"plain error", func(p Provider, _ ChatSession) (string, error) {
+			_, err := p.GenerateText(context.Background(), genai.Text("broken"))
+			return "", err
+		}},
+	}
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recorder, err := NewRecorder(NewFakeProvider(script, "test-model"), "test-model", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := make([]string, len(calls))
+	recordedErrs := make([]error, len(calls))
+	chat := recorder.StartChat()
+	for i, c := range calls {
+		recorded[i], recordedErrs[i] = c.run(recorder, chat)
+	}
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat = replayer.StartChat()
+	for i, c := range calls {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.run(replayer, chat)
+			if got != recorded[i] {
+				t.Errorf("replayed %q, recorded %q", got, recorded[i])
+			}
+			if (err == nil) != (recordedErrs[i] == nil) {
+				t.Fatalf("replayed error %v, recorded %v", err, recordedErrs[i])
+			}
+			if err == nil {
+				return
+			}
+
+			var recordedAPI, replayedAPI *apierror.APIError
+			if errors.As(recordedErrs[i], &recordedAPI) != errors.As(err, &replayedAPI) {
+				t.Fatalf("replayed error %v, recorded %v", err, recordedErrs[i])
+			}
+			if recordedAPI != nil && recordedAPI.HTTPCode() != replayedAPI.HTTPCode() {
+				t.Errorf("replayed status %d, recorded %d", replayedAPI.HTTPCode(), recordedAPI.HTTPCode())
+			}
+		})
+	}
+
+	if _, err := replayer.GenerateText(context.Background(), genai.Text("one more")); err == nil {
+		t.Error("replaying past the end of the cassette succeeded")
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recorder, err := NewRecorder(NewFakeProvider(nil, ""), "", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recorder.GenerateText(context.Background(), genai.Text("recorded")); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = replayer.GenerateText(context.Background(), genai.Text("different"))
+	if err == nil || !strings.Contains(err.Error(), `interaction 1 is generate "recorded"`) {
+		t.Errorf("error = %v, want a mismatch naming the recorded interaction", err)
+	}
+}
+
+// failingProvider is a fake backend whose generations fail with err
+type failingProvider struct {
+	*FakeProvider
+	err error
+}
+
+func (p *failingProvider) GenerateText(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	return nil, p.err
+}
+
+func TestReplayErrorKinds(t *testing.T) {
+	blocked := &genai.BlockedError{
+		Candidate: &genai.Candidate{
+			FinishReason:  genai.FinishReasonSafety,
+			SafetyRatings: []*genai.SafetyRating{{Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityHigh, Blocked: true}},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		check func(err error) bool
+	}{
+		{
+			name: "blocked",
+			err:  fmt.Errorf("failed to generate: %w", blocked),
+			check: func(err error) bool {
+				var replayed *genai.BlockedError
+				return errors.As(err, &replayed) && replayed.Candidate != nil &&
+					replayed.Candidate.FinishReason == genai.FinishReasonSafety && len(replayed.Candidate.SafetyRatings) == 1
+			},
+		},
+		{
+			name:  "canceled",
+			err:   fmt.Errorf("failed to get next response: %w", context.Canceled),
+			check: func(err error) bool { return errors.Is(err, context.Canceled) },
+		},
+		{
+			name:  "deadline exceeded",
+			err:   fmt.Errorf("failed to get next response: %w", context.DeadlineExceeded),
+			check: func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cassette.json")
+			recorder, err := NewRecorder(&failingProvider{FakeProvider: NewFakeProvider(nil, ""), err: tt.err}, "", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := recorder.GenerateText(context.Background(), genai.Text("hi")); err != tt.err {
+				t.Fatalf("recorded error %v, want %v", err, tt.err)
+			}
+
+			replayer, err := NewReplayer(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = replayer.GenerateText(context.Background(), genai.Text("hi"))
+			if err == nil || err.Error() != tt.err.Error() {
+				t.Fatalf("replayed error %v, want %v", err, tt.err)
+			}
+			if !tt.check(err) {
+				t.Errorf("replayed error %v isn't the same kind as %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -4,39 +4,88 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
-// Client wraps the Gemini API client
+// DefaultModel is the model used when none is configured
+const DefaultModel = "gemini-1.5-pro-latest"
+
+// defaultTemperature is used when Settings leaves Temperature unset
+const defaultTemperature = 0.7
+
+// Client wraps the Gemini API client and implements Provider
 type Client struct {
-	client *genai.Client
-	model  *genai.GenerativeModel
-	ctx    context.Context
+	client   *genai.Client
+	model    *genai.GenerativeModel
+	settings Settings
 }
 
 // NewClient creates a new Gemini client
-func NewClient(apiKey string, modelName string) (*Client, error) {
+func NewClient(ctx context.Context, apiKey string, modelName string, settings Settings) (*Client, error) {
+	return newClient(ctx, modelName, settings, option.WithAPIKey(apiKey))
+}
+
+// newClient creates a Gemini client with the given connection options
+func newClient(ctx context.Context, modelName string, settings Settings, opts ...option.ClientOption) (*Client, error) {
 	if modelName == "" {
-		modelName = "gemini-1.5-pro-latest"
+		modelName = DefaultModel
 	}
 
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	client, err := genai.NewClient(ctx, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Gemini client: %v", err)
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+
+	c := &Client{
+		client:   client,
+		settings: settings,
+	}
+	c.model = c.newModel(modelName)
+	return c, nil
+}
+
+// newModel creates a GenerativeModel configured with the client's settings
+func (c *Client) newModel(modelName string) *genai.GenerativeModel {
+	model := c.client.GenerativeModel(modelName)
+	c.applySettings(model)
+	return model
+}
+
+// applySettings configures model with the client's settings. Chat sessions
+// share the model, so this also affects their next message.
+func (c *Client) applySettings(model *genai.GenerativeModel) {
+	model.GenerationConfig = genai.GenerationConfig{
+		Temperature:     genai.Ptr[float32](defaultTemperature),
+		TopP:            c.settings.TopP,
+		TopK:            c.settings.TopK,
+		MaxOutputTokens: c.settings.MaxOutputTokens,
+		CandidateCount:  c.settings.CandidateCount,
+		StopSequences:   c.settings.StopSequences,
+	}
+	if c.settings.Temperature != nil {
+		model.Temperature = genai.Ptr(*c.settings.Temperature)
 	}
 
-	model := client.GenerativeModel(modelName)
-	model.Temperature = genai.Ptr[float32](0.7)
+	model.SystemInstruction = nil
+	if c.settings.SystemInstruction != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(c.settings.SystemInstruction))
+	}
+}
+
+// Settings returns the current generation settings
+func (c *Client) Settings() Settings {
+	return c.settings
+}
 
-	return &Client{
-		client: client,
-		model:  model,
-		ctx:    ctx,
-	}, nil
+// SetSettings replaces the generation settings
+func (c *Client) SetSettings(settings Settings) error {
+	c.settings = settings
+	c.applySettings(c.model)
+	return nil
 }
 
 // Close closes the client
@@ -44,46 +93,105 @@ func (c *Client) Close() error {
 	return c.client.Close()
 }
 
-// GenerateText generates text from a prompt
-func (c *Client) GenerateText(ctx context.Context, prompt string) (string, error) {
-	resp, err := c.model.GenerateContent(ctx, genai.Text(prompt))
+// GenerateText generates a response to the parts of a prompt
+func (c *Client) GenerateText(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := c.model.GenerateContent(ctx, parts...)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate content: %v", err)
+		return nil, fmt.Errorf("failed to generate content: %w", err)
 	}
 
-	return responseToString(resp), nil
+	return resp, nil
+}
+
+// GenerateTextStream generates a response to the parts of a prompt and streams it
+func (c *Client) GenerateTextStream(ctx context.Context, writer io.Writer, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	return readStream(c.model.GenerateContentStream(ctx, parts...), func(text string) error {
+		if _, err := fmt.Fprint(writer, text); err != nil {
+			return fmt.Errorf("failed to write response: %w", err)
+		}
+		return nil
+	})
+}
+
+// responseStream is the part of genai's streaming iterator the client reads
+type responseStream interface {
+	Next() (*genai.GenerateContentResponse, error)
+	MergedResponse() *genai.GenerateContentResponse
 }
 
-// GenerateTextStream generates text from a prompt and streams the response
-func (c *Client) GenerateTextStream(ctx context.Context, prompt string, writer io.Writer) error {
-	iter := c.model.GenerateContentStream(ctx, genai.Text(prompt))
+// readStream reads a stream to the end, passing the text of each chunk to
+// onText, and returns the merged response. Each chunk reports the usage so
+// far, but genai's merged response keeps the first chunk's, so the last
+// one is set on it instead. If the stream fails, the response returned
+// with the error carries only the usage so far, since those tokens were
+// billed anyway.
+func readStream(stream responseStream, onText func(text string) error) (*genai.GenerateContentResponse, error) {
+	var usage *genai.UsageMetadata
+	partial := func() *genai.GenerateContentResponse {
+		if usage == nil {
+			return nil
+		}
+		return &genai.GenerateContentResponse{UsageMetadata: usage}
+	}
 
 	for {
-		resp, err := iter.Next()
-		if err == io.EOF {
+		resp, err := stream.Next()
+		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to get next response: %v", err)
+			return partial(), fmt.Errorf("failed to get next response: %w", err)
 		}
-
-		text := responseToString(resp)
-		if _, err := fmt.Fprint(writer, text); err != nil {
-			return fmt.Errorf("failed to write response: %v", err)
+		if resp.UsageMetadata != nil {
+			usage = resp.UsageMetadata
+		}
+		if err := onText(responseToString(resp)); err != nil {
+			return partial(), err
 		}
 	}
 
-	return nil
+	resp := stream.MergedResponse()
+	if resp != nil && usage != nil {
+		resp.UsageMetadata = usage
+	}
+	return resp, nil
 }
 
 // StartChat starts a new chat session
-func (c *Client) StartChat() *genai.ChatSession {
-	return c.model.StartChat()
+func (c *Client) StartChat() ChatSession {
+	return &chatSession{session: c.model.StartChat(), model: c.model}
+}
+
+// CountTokens counts the input tokens of a prompt with the current model
+func (c *Client) CountTokens(ctx context.Context, parts ...genai.Part) (int32, error) {
+	return countTokens(ctx, c.model, parts)
+}
+
+// ModelInfo describes the current model
+func (c *Client) ModelInfo(ctx context.Context) (*genai.ModelInfo, error) {
+	info, err := c.model.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model details: %w", err)
+	}
+	return info, nil
+}
+
+// countTokens counts the input tokens of parts with model, including its
+// system instruction
+func countTokens(ctx context.Context, model *genai.GenerativeModel, parts []genai.Part) (int32, error) {
+	if len(parts) == 0 {
+		return 0, nil
+	}
+	resp, err := model.CountTokens(ctx, parts...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count tokens: %w", err)
+	}
+	return resp.TotalTokens, nil
 }
 
 // ListModels lists all available models
-func (c *Client) ListModels() ([]*genai.ModelInfo, error) {
-	iter := c.client.ListModels(c.ctx)
+func (c *Client) ListModels(ctx context.Context) ([]*genai.ModelInfo, error) {
+	iter := c.client.ListModels(ctx)
 	var models []*genai.ModelInfo
 
 	for {
@@ -92,7 +200,7 @@ func (c *Client) ListModels() ([]*genai.ModelInfo, error) {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to list models: %v", err)
+			return nil, fmt.Errorf("failed to list models: %w", err)
 		}
 		models = append(models, model)
 	}
@@ -106,15 +214,21 @@ func (c *Client) SwitchModel(modelName string) error {
 		return fmt.Errorf("model name cannot be empty")
 	}
 
-	c.model = c.client.GenerativeModel(modelName)
-	c.model.Temperature = genai.Ptr[float32](0.7)
+	c.model = c.newModel(modelName)
 	return nil
 }
 
-// responseToString extracts text from a GenerateContentResponse
+// responseToString extracts text from a GenerateContentResponse. Multiple
+// candidates are separated by a horizontal rule.
 func responseToString(resp *genai.GenerateContentResponse) string {
 	var result string
-	for _, candidate := range resp.Candidates {
+	if resp == nil {
+		return result
+	}
+	for i, candidate := range resp.Candidates {
+		if i > 0 {
+			result += "\n\n---\n\n"
+		}
 		if candidate.Content != nil {
 			for _, part := range candidate.Content.Parts {
 				if text, ok := part.(genai.Text); ok {
@@ -125,3 +239,64 @@ func responseToString(resp *genai.GenerateContentResponse) string {
 	}
 	return result
 }
+
+// chatSession adapts genai.ChatSession to the ChatSession interface
+type chatSession struct {
+	session *genai.ChatSession
+	model   *genai.GenerativeModel
+}
+
+// SendMessage sends a message as part of the chat session
+func (s *chatSession) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	// genai appends the message to the history before sending it, so drop it
+	// again on failure to keep the history in user/model pairs
+	history := s.session.History
+	resp, err := s.session.SendMessage(ctx, parts...)
+	if err != nil {
+		s.session.History = history
+		return nil, err
+	}
+	return resp, nil
+}
+
+// SendMessageStream sends a message as part of the chat session and streams
+// the reply to onChunk
+func (s *chatSession) SendMessageStream(ctx context.Context, onChunk func(text string), parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	history := s.session.History
+	iter := s.session.SendMessageStream(ctx, parts...)
+
+	var partial strings.Builder
+	resp, err := readStream(iter, func(text string) error {
+		if text != "" {
+			partial.WriteString(text)
+			onChunk(text)
+		}
+		return nil
+	})
+	if err != nil {
+		s.session.History = withPartialReply(history, parts, partial.String())
+		return resp, err
+	}
+	if resp == nil {
+		// genai only records the reply once something arrived
+		s.session.History = history
+		return nil, fmt.Errorf("empty response from model")
+	}
+	return resp, nil
+}
+
+// CountTokens counts the input tokens of the history and a message. The
+// API counts a single prompt, so the turns are counted as one.
+func (s *chatSession) CountTokens(ctx context.Context, parts ...genai.Part) (int32, error) {
+	return countTokens(ctx, s.model, historyParts(s.session.History, parts))
+}
+
+// History returns the conversation so far
+func (s *chatSession) History() []*genai.Content {
+	return s.session.History
+}
+
+// SetHistory replaces the conversation history
+func (s *chatSession) SetHistory(history []*genai.Content) {
+	s.session.History = history
+}
@@ -0,0 +1,256 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/vandi/gemi/internal/usage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// fakeStream serves chunks like genai's iterator, then fails with err if
+// set. Like genai, its merged response keeps the first chunk's usage.
+type fakeStream struct {
+	chunks []*genai.GenerateContentResponse
+	err    error
+	next   int
+	merged *genai.GenerateContentResponse
+}
+
+func (s *fakeStream) Next() (*genai.GenerateContentResponse, error) {
+	if s.next == len(s.chunks) {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, iterator.Done
+	}
+	chunk := s.chunks[s.next]
+	s.next++
+	if s.merged == nil {
+		s.merged = &genai.GenerateContentResponse{UsageMetadata: chunk.UsageMetadata}
+	}
+	return chunk, nil
+}
+
+func (s *fakeStream) MergedResponse() *genai.GenerateContentResponse {
+	return s.merged
+}
+
+// usageChunk is a streamed chunk reporting the usage so far
+func usageChunk(text string, prompt int32, candidates int32) *genai.GenerateContentResponse {
+	chunk := textResponse(text)
+	chunk.UsageMetadata = &genai.UsageMetadata{
+		PromptTokenCount:     prompt,
+		CandidatesTokenCount: candidates,
+		TotalTokenCount:      prompt + candidates,
+	}
+	return chunk
+}
+
+func TestReadStream(t *testing.T) {
+	chunks := []*genai.GenerateContentResponse{
+		usageChunk("The quick ", 12, 3),
+		usageChunk("brown fox ", 12, 6),
+		usageChunk("jumps.", 12, 8),
+	}
+	broken := errors.New("connection reset")
+
+	tests := []struct {
+		name      string
+		stream    *fakeStream
+		writeErr  error
+		wantText  string
+		wantTotal int32 // 0 for no response
+		wantErr   error
+	}{
+		{
+			name:      "last chunk's usage",
+			stream:    &fakeStream{chunks: chunks},
+			wantText:  "The quick brown fox jumps.",
+			wantTotal: 20,
+		},
+		{
+			name:      "last chunk without usage",
+			stream:    &fakeStream{chunks: append(chunks[:2:2], textResponse("jumps."))},
+			wantText:  "The quick brown fox jumps.",
+			wantTotal: 18,
+		},
+		{
+			name:      "failed part way",
+			stream:    &fakeStream{chunks: chunks[:2], err: broken},
+			wantText:  "The quick brown fox ",
+			wantTotal: 18,
+			wantErr:   broken,
+		},
+		{
+			name:    "failed before any chunk",
+			stream:  &fakeStream{err: broken},
+			wantErr: broken,
+		},
+		{
+			name:      "write failed",
+			stream:    &fakeStream{chunks: chunks},
+			writeErr:  io.ErrClosedPipe,
+			wantTotal: 15,
+			wantErr:   io.ErrClosedPipe,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var text strings.Builder
+			resp, err := readStream(tt.stream, func(s string) error {
+				if tt.writeErr != nil {
+					return tt.writeErr
+				}
+				text.WriteString(s)
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if text.String() != tt.wantText {
+				t.Errorf("streamed %q, want %q", text.String(), tt.wantText)
+			}
+
+			var total int32
+			if resp != nil && resp.UsageMetadata != nil {
+				total = resp.UsageMetadata.TotalTokenCount
+			}
+			if total != tt.wantTotal {
+				t.Errorf("response has %d total tokens, want %d", total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+// streamingProvider is a fake backend that streams its replies as chunks
+// reporting their usage so far
+type streamingProvider struct {
+	*FakeProvider
+	chunks []*genai.GenerateContentResponse
+}
+
+func (p *streamingProvider) GenerateTextStream(ctx context.Context, writer io.Writer, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	return readStream(&fakeStream{chunks: p.chunks}, func(text string) error {
+		_, err := io.WriteString(writer, text)
+		return err
+	})
+}
+
+// jsonChunk is a streamed chunk in the REST API's JSON
+func jsonChunk(text string, prompt int, candidates int) string {
+	return fmt.Sprintf(`{"candidates":[{"content":{"role":"model","parts":[{"text":%q}]}}],`+
+		`"usageMetadata":{"promptTokenCount":%d,"candidatesTokenCount":%d,"totalTokenCount":%d}}`,
+		text, prompt, candidates, prompt+candidates)
+}
+
+// newCutOffClient returns a client whose streamed replies are cut off part
+// way through the chunk after chunks
+func newCutOffClient(t *testing.T, chunks ...string) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "["+strings.Join(chunks, ",")+`,{"candidates":[{"content":`)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := newClient(context.Background(), "test-model", Settings{},
+		option.WithAPIKey("test"), option.WithEndpoint(srv.URL), option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestStreamUsageLedger(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider func(t *testing.T) Provider
+		stream   func(p Provider) error
+		wantErr  bool
+	}{
+		{
+			name: "streamed generate",
+			provider: func(t *testing.T) Provider {
+				return &streamingProvider{
+					FakeProvider: NewFakeProvider(nil, ""),
+					chunks:       []*genai.GenerateContentResponse{usageChunk("The quick ", 12, 3), usageChunk("brown fox ", 12, 5), usageChunk("jumps.", 12, 8)},
+				}
+			},
+			stream: func(p Provider) error {
+				_, err := p.GenerateTextStream(context.Background(), io.Discard, genai.Text("hi"))
+				return err
+			},
+		},
+		{
+			name: "generate cut off",
+			provider: func(t *testing.T) Provider {
+				return newCutOffClient(t, jsonChunk("The quick ", 12, 3), jsonChunk("brown fox jumps.", 12, 8))
+			},
+			stream: func(p Provider) error {
+				_, err := p.GenerateTextStream(context.Background(), io.Discard, genai.Text("hi"))
+				return err
+			},
+			wantErr: true,
+		},
+		{
+			name: "chat cut off",
+			provider: func(t *testing.T) Provider {
+				return newCutOffClient(t, jsonChunk("The quick ", 12, 3), jsonChunk("brown fox jumps.", 12, 8))
+			},
+			stream: func(p Provider) error {
+				_, err := p.StartChat().SendMessageStream(context.Background(), func(string) {}, genai.Text("hi"))
+				return err
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_DATA_HOME", t.TempDir())
+			meter := NewMeter(tt.provider(t), "test-model", func(model string, u *genai.UsageMetadata) {
+				err := usage.Append(usage.Entry{
+					Time:             time.Now(),
+					Model:            model,
+					PromptTokens:     u.PromptTokenCount,
+					CandidatesTokens: u.CandidatesTokenCount,
+					TotalTokens:      u.TotalTokenCount,
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			})
+
+			if err := tt.stream(meter); (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			entries, err := usage.Load(time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("ledger has %d entries, want 1", len(entries))
+			}
+			if e := entries[0]; e.PromptTokens != 12 || e.CandidatesTokens != 8 || e.TotalTokens != 20 {
+				t.Errorf("recorded %d+%d=%d tokens, want 12+8=20", e.PromptTokens, e.CandidatesTokens, e.TotalTokens)
+			}
+		})
+	}
+}
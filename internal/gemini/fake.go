@@ -0,0 +1,439 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+)
+
+// defaultFakeChunkSize is the number of runes per streamed chunk when a
+// scripted response doesn't list its chunks explicitly
+const defaultFakeChunkSize = 16
+
+// FakeScript describes the behavior of a FakeProvider
+type FakeScript struct {
+	// Models is returned by ListModels. A fixed list is used when empty.
+	Models []FakeModel `json:"models,omitempty"`
+
+	// ListModelsError makes ListModels fail with this message
+	ListModelsError string `json:"list_models_error,omitempty"`
+
+	// Responses are served in order. Responses with Match set are only
+	// served for prompts containing Match, and are never used up.
+	Responses []FakeResponse `json:"responses,omitempty"`
+
+	// ChunkSize is the number of runes per streamed chunk
+	ChunkSize int `json:"chunk_size,omitempty"`
+
+	// ChunkDelayMS pauses this many milliseconds before each streamed chunk
+	ChunkDelayMS int `json:"chunk_delay_ms,omitempty"`
+}
+
+// FakeModel is a model listed by a FakeProvider
+type FakeModel struct {
+	Name        string `json:"name"`
+	BaseModelID string `json:"base_model_id"`
+	Version     string `json:"version"`
+
+	InputTokenLimit  int32 `json:"input_token_limit,omitempty"`
+	OutputTokenLimit int32 `json:"output_token_limit,omitempty"`
+}
+
+// FakeResponse is a scripted reply of a FakeProvider
+type FakeResponse struct {
+	// Match restricts the response to prompts containing this text
+	Match string `json:"match,omitempty"`
+
+	// Text is the reply
+	Text string `json:"text,omitempty"`
+
+	// Chunks overrides how Text is split when streaming
+	Chunks []string `json:"chunks,omitempty"`
+
+	// Error makes the request fail with this message
+	Error string `json:"error,omitempty"`
+
+	// Status makes Error an API error with this HTTP status, e.g. 429 for
+	// an exhausted quota
+	Status int `json:"status,omitempty"`
+}
+
+// LoadFakeScript reads a FakeScript from a JSON file
+func LoadFakeScript(path string) (*FakeScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fake script: %v", err)
+	}
+
+	var script FakeScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse fake script %s: %v", path, err)
+	}
+	return &script, nil
+}
+
+// FakeProvider is a deterministic in-process Provider that needs no network.
+// Without a script it echoes every prompt back.
+type FakeProvider struct {
+	mu       sync.Mutex
+	script   FakeScript
+	model    string
+	settings Settings
+	next     int
+}
+
+// NewFakeProvider creates a fake backend. script may be nil.
+func NewFakeProvider(script *FakeScript, modelName string) *FakeProvider {
+	if modelName == "" {
+		modelName = DefaultModel
+	}
+
+	p := &FakeProvider{model: modelName}
+	if script != nil {
+		p.script = *script
+	}
+	if p.script.ChunkSize <= 0 {
+		p.script.ChunkSize = defaultFakeChunkSize
+	}
+	return p
+}
+
+// Close is a no-op
+func (p *FakeProvider) Close() error {
+	return nil
+}
+
+// GenerateText returns the next scripted response
+func (p *FakeProvider) GenerateText(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	prompt := partsToString(parts)
+	resp, err := p.respond(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate content: %w", err)
+	}
+	return fakeReply(prompt, resp.Text), nil
+}
+
+// GenerateTextStream writes the next scripted response to writer in chunks
+func (p *FakeProvider) GenerateTextStream(ctx context.Context, writer io.Writer, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	prompt := partsToString(parts)
+	resp, err := p.respond(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next response: %w", err)
+	}
+
+	for _, chunk := range p.chunks(resp) {
+		if err := p.wait(ctx); err != nil {
+			return nil, fmt.Errorf("failed to get next response: %w", err)
+		}
+		if _, err := fmt.Fprint(writer, chunk); err != nil {
+			return nil, fmt.Errorf("failed to write response: %w", err)
+		}
+	}
+	return fakeReply(prompt, resp.Text), nil
+}
+
+// StartChat starts a new fake chat session
+func (p *FakeProvider) StartChat() ChatSession {
+	return &localChatSession{
+		send: func(ctx context.Context, prompt string, onChunk func(string)) (*genai.GenerateContentResponse, error) {
+			resp, err := p.respond(ctx, prompt)
+			if err != nil {
+				return nil, err
+			}
+			if onChunk != nil {
+				for _, chunk := range p.chunks(resp) {
+					if err := p.wait(ctx); err != nil {
+						return nil, fmt.Errorf("failed to get next response: %w", err)
+					}
+					onChunk(chunk)
+				}
+			}
+			return fakeReply(prompt, resp.Text), nil
+		},
+	}
+}
+
+// CountTokens estimates the input tokens of a prompt
+func (p *FakeProvider) CountTokens(ctx context.Context, parts ...genai.Part) (int32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to count tokens: %w", err)
+	}
+	return estimateTokens(partsToString(parts)), nil
+}
+
+// ListModels returns the scripted models
+func (p *FakeProvider) ListModels(ctx context.Context) ([]*genai.ModelInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	if p.script.ListModelsError != "" {
+		return nil, fmt.Errorf("failed to list models: %s", p.script.ListModelsError)
+	}
+	return p.models(), nil
+}
+
+// ModelInfo describes the current model if it's one of the scripted models
+func (p *FakeProvider) ModelInfo(ctx context.Context) (*genai.ModelInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get model details: %w", err)
+	}
+
+	p.mu.Lock()
+	name := "models/" + p.model
+	p.mu.Unlock()
+	for _, m := range p.models() {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to get model details: %w", fakeError(FakeResponse{Error: "model " + name + " not found", Status: 404}))
+}
+
+// models converts the scripted models, or a fixed list if there are none
+func (p *FakeProvider) models() []*genai.ModelInfo {
+	fakeModels := p.script.Models
+	if len(fakeModels) == 0 {
+		fakeModels = []FakeModel{
+			{Name: "gemini-1.5-flash-latest", BaseModelID: "gemini-1.5-flash", Version: "001", InputTokenLimit: 1048576, OutputTokenLimit: 8192},
+			{Name: "gemini-1.5-pro-latest", BaseModelID: "gemini-1.5-pro", Version: "001", InputTokenLimit: 2097152, OutputTokenLimit: 8192},
+		}
+	}
+
+	models := make([]*genai.ModelInfo, 0, len(fakeModels))
+	for _, m := range fakeModels {
+		models = append(models, &genai.ModelInfo{
+			Name:             "models/" + m.Name,
+			BaseModelID:      m.BaseModelID,
+			Version:          m.Version,
+			DisplayName:      m.Name,
+			InputTokenLimit:  m.InputTokenLimit,
+			OutputTokenLimit: m.OutputTokenLimit,
+		})
+	}
+	return models
+}
+
+// SwitchModel switches to a different model
+func (p *FakeProvider) SwitchModel(modelName string) error {
+	if modelName == "" {
+		return fmt.Errorf("model name cannot be empty")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.model = modelName
+	return nil
+}
+
+// Settings returns the current generation settings
+func (p *FakeProvider) Settings() Settings {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.settings
+}
+
+// SetSettings replaces the generation settings. The fake ignores them.
+func (p *FakeProvider) SetSettings(settings Settings) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settings = settings
+	return nil
+}
+
+// respond picks the scripted response for prompt
+func (p *FakeProvider) respond(ctx context.Context, prompt string) (FakeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return FakeResponse{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	resp, ok := p.pick(prompt)
+	if !ok {
+		resp = FakeResponse{Text: fmt.Sprintf("[%s] %s", p.model, prompt)}
+	}
+	if resp.Error != "" {
+		return FakeResponse{}, fakeError(resp)
+	}
+	if resp.Text == "" && len(resp.Chunks) > 0 {
+		resp.Text = strings.Join(resp.Chunks, "")
+	}
+	return resp, nil
+}
+
+// fakeError builds the error of a failed response, shaped like an error
+// from the API if it has a Status
+func fakeError(resp FakeResponse) error {
+	if resp.Status == 0 {
+		return errors.New(resp.Error)
+	}
+	if apiErr, ok := apierror.FromError(&googleapi.Error{Code: resp.Status, Message: resp.Error}); ok {
+		return apiErr
+	}
+	return errors.New(resp.Error)
+}
+
+// pick returns the first matching response, or the next unmatched one in order
+func (p *FakeProvider) pick(prompt string) (FakeResponse, bool) {
+	for _, resp := range p.script.Responses {
+		if resp.Match != "" && strings.Contains(prompt, resp.Match) {
+			return resp, true
+		}
+	}
+
+	for p.next < len(p.script.Responses) {
+		resp := p.script.Responses[p.next]
+		p.next++
+		if resp.Match == "" {
+			return resp, true
+		}
+	}
+	return FakeResponse{}, false
+}
+
+// chunks splits a response into the pieces it is streamed in
+func (p *FakeProvider) chunks(resp FakeResponse) []string {
+	if len(resp.Chunks) > 0 {
+		return resp.Chunks
+	}
+
+	var chunks []string
+	runes := []rune(resp.Text)
+	for len(runes) > 0 {
+		n := min(p.script.ChunkSize, len(runes))
+		chunks = append(chunks, string(runes[:n]))
+		runes = runes[n:]
+	}
+	return chunks
+}
+
+// wait pauses for the scripted chunk delay, or until ctx is done
+func (p *FakeProvider) wait(ctx context.Context) error {
+	if p.script.ChunkDelayMS <= 0 {
+		return ctx.Err()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(p.script.ChunkDelayMS) * time.Millisecond):
+		return nil
+	}
+}
+
+// localChatSession is a chat session that keeps its history in memory and
+// gets replies from send, which streams the reply to onChunk unless it is
+// nil. It backs the fake and replay providers.
+type localChatSession struct {
+	send    func(ctx context.Context, prompt string, onChunk func(string)) (*genai.GenerateContentResponse, error)
+	history []*genai.Content
+}
+
+// SendMessage gets a reply from send and records the exchange
+func (s *localChatSession) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := s.send(ctx, partsToString(parts), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.addReply(parts, resp)
+	return resp, nil
+}
+
+// SendMessageStream gets a reply from send, streaming it to onChunk, and
+// records the exchange, or as much of the reply as arrived
+func (s *localChatSession) SendMessageStream(ctx context.Context, onChunk func(text string), parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	var partial strings.Builder
+	resp, err := s.send(ctx, partsToString(parts), func(text string) {
+		partial.WriteString(text)
+		onChunk(text)
+	})
+	if err != nil {
+		s.history = withPartialReply(s.history, parts, partial.String())
+		return nil, err
+	}
+	s.addReply(parts, resp)
+	return resp, nil
+}
+
+// addReply appends a message and its reply to the history
+func (s *localChatSession) addReply(parts []genai.Part, resp *genai.GenerateContentResponse) {
+	reply := &genai.Content{Role: "model"}
+	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
+		reply.Parts = resp.Candidates[0].Content.Parts
+	}
+	s.history = append(s.history, genai.NewUserContent(parts...), reply)
+}
+
+// CountTokens estimates the input tokens of the history and a message
+func (s *localChatSession) CountTokens(ctx context.Context, parts ...genai.Part) (int32, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to count tokens: %w", err)
+	}
+	return estimateTokens(partsToString(historyParts(s.history, parts))), nil
+}
+
+// History returns the conversation so far
+func (s *localChatSession) History() []*genai.Content {
+	return s.history
+}
+
+// SetHistory replaces the conversation history
+func (s *localChatSession) SetHistory(history []*genai.Content) {
+	s.history = history
+}
+
+// textResponse builds a single-candidate response holding text
+func textResponse(text string) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			Content: &genai.Content{
+				Role:  "model",
+				Parts: []genai.Part{genai.Text(text)},
+			},
+			FinishReason: genai.FinishReasonStop,
+		}},
+	}
+}
+
+// fakeReply builds the response to prompt, with estimated token usage
+func fakeReply(prompt string, text string) *genai.GenerateContentResponse {
+	reply := textResponse(text)
+	reply.UsageMetadata = fakeUsage(prompt, text)
+	return reply
+}
+
+// fakeUsage estimates token usage at about four characters per token
+func fakeUsage(prompt string, reply string) *genai.UsageMetadata {
+	promptTokens, replyTokens := estimateTokens(prompt), estimateTokens(reply)
+	return &genai.UsageMetadata{
+		PromptTokenCount:     promptTokens,
+		CandidatesTokenCount: replyTokens,
+		TotalTokenCount:      promptTokens + replyTokens,
+	}
+}
+
+// partsToString joins the parts of a message with blank lines, describing
+// binary parts by type and size
+func partsToString(parts []genai.Part) string {
+	var texts []string
+	for _, part := range parts {
+		switch part := part.(type) {
+		case genai.Text:
+			texts = append(texts, string(part))
+		case genai.Blob:
+			texts = append(texts, fmt.Sprintf("[%s, %d bytes]", part.MIMEType, len(part.Data)))
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
@@ -0,0 +1,147 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+)
+
+func TestFakeProviderGenerateText(t *testing.T) {
+	script := &FakeScript{Responses: []FakeResponse{
+		{Text: "first"},
+		{Match: "weather", Text: "sunny"},
+		{Text: "second"},
+		{Match: "quota", Error: "quota exhausted", Status: 429},
+		{Match: "broken", Error: "plain failure"},
+	}}
+
+	tests := []struct {
+		name    string
+		prompt  string
+		want    string
+		wantErr string
+		status  int
+	}{
+		{name: "first in order", prompt: "hello", want: "first"},
+		{name: "match wins over order", prompt: "what's the weather?", want: "sunny"},
+		{name: "match is never used up", prompt: "weather again", want: "sunny"},
+		{name: "second in order", prompt: "hello", want: "second"},
+		{name: "echo once the script runs out", prompt: "hello", want: "[test-model] hello"},
+		{name: "api error", prompt: "quota", wantErr: "quota exhausted", status: 429},
+		{name: "plain error", prompt: "broken", wantErr: "plain failure"},
+	}
+
+	p := NewFakeProvider(script, "test-model")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := p.GenerateText(context.Background(), genai.Text(tt.prompt))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				var apiErr *apierror.APIError
+				isAPI := errors.As(err, &apiErr)
+				if tt.status != 0 && (!isAPI || apiErr.HTTPCode() != tt.status) {
+					t.Errorf("error %v isn't an API error with status %d", err, tt.status)
+				}
+				if tt.status == 0 && isAPI {
+					t.Errorf("error %v is an API error, want a plain one", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := ResponseText(resp); got != tt.want {
+				t.Errorf("reply = %q, want %q", got, tt.want)
+			}
+			if resp.UsageMetadata == nil || resp.UsageMetadata.TotalTokenCount == 0 {
+				t.Errorf("reply has no usage: %+v", resp.UsageMetadata)
+			}
+		})
+	}
+}
+
+func TestFakeProviderChunks(t *testing.T) {
+	tests := []struct {
+		name   string
+		script FakeScript
+		want   []string
+	}{
+		{
+			name:   "default chunk size",
+			script: FakeScript{Responses: []FakeResponse{{Text: "The quick brown fox jumps"}}},
+			want:   []string{"The quick brown ", "fox jumps"},
+		},
+		{
+			name:   "chunk size counts runes",
+			script: FakeScript{ChunkSize: 2, Responses: []FakeResponse{{Text: "héllo"}}},
+			want:   []string{"hé", "ll", "o"},
+		},
+		{
+			name:   "explicit chunks",
+			script: FakeScript{Responses: []FakeResponse{{Chunks: []string{"one ", "two"}}}},
+			want:   []string{"one ", "two"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFakeProvider(&tt.script, "")
+			var sb strings.Builder
+			resp, err := p.GenerateTextStream(context.Background(), &sb, genai.Text("go"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := strings.Join(tt.want, "")
+			if sb.String() != want || ResponseText(resp) != want {
+				t.Errorf("streamed %q and returned %q, want %q", sb.String(), ResponseText(resp), want)
+			}
+
+			var chunks []string
+			session := NewFakeProvider(&tt.script, "").StartChat()
+			_, err = session.SendMessageStream(context.Background(), func(text string) {
+				chunks = append(chunks, text)
+			}, genai.Text("go"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(chunks, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("chunks = %q, want %q", chunks, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeChatHistory(t *testing.T) {
+	script := &FakeScript{Responses: []FakeResponse{
+		{Text: "hi there"},
+		{Chunks: []string{"partial ", "reply"}},
+	}}
+	p := NewFakeProvider(script, "")
+	session := p.StartChat()
+
+	if _, err := session.SendMessage(context.Background(), genai.Text("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A cancelled stream keeps what arrived before the cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := session.SendMessageStream(ctx, func(text string) { cancel() }, genai.Text("more"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+
+	var got []string
+	for _, c := range session.History() {
+		got = append(got, c.Role+": "+partsToString(c.Parts))
+	}
+	want := []string{"user: hello", "model: hi there", "user: more", "model: partial "}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+}
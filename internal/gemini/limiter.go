@@ -0,0 +1,335 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// RateLimit is a client-side budget of requests and tokens per minute for
+// each model. Zero fields are unlimited.
+type RateLimit struct {
+	RPM int
+	TPM int
+}
+
+// Unlimited reports whether the limit allows everything
+func (l RateLimit) Unlimited() bool {
+	return l.RPM <= 0 && l.TPM <= 0
+}
+
+// rateWindow is the period RateLimit budgets cover
+const rateWindow = time.Minute
+
+// staleLock is how old a lock file must be before it's assumed to have
+// been left behind by a process that died holding it
+const staleLock = 10 * time.Second
+
+// Limiter is a Provider that keeps the requests of another Provider within
+// a RateLimit per model, making callers wait for room in the budget rather
+// than failing. Usage is kept in a state file guarded by a lock file, so
+// gemi processes running at the same time share one budget.
+type Limiter struct {
+	Provider
+	limit RateLimit
+	path  string
+
+	// onWait, if set, is called before waiting for room in the budget
+	onWait func(model string, wait time.Duration)
+
+	mu    sync.Mutex
+	model string
+}
+
+// NewLimiter wraps provider, which is using model, to stay within limit.
+// Its state is kept in dir.
+func NewLimiter(provider Provider, model string, limit RateLimit, dir string, onWait func(model string, wait time.Duration)) *Limiter {
+	return &Limiter{
+		Provider: provider,
+		limit:    limit,
+		path:     filepath.Join(dir, "ratelimit.json"),
+		onWait:   onWait,
+		model:    model,
+	}
+}
+
+// GenerateText waits for room in the budget and generates a response
+func (l *Limiter) GenerateText(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	r, err := l.reserve(ctx, estimateTokens(partsToString(parts)))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.Provider.GenerateText(ctx, parts...)
+	l.settle(r, resp)
+	return resp, err
+}
+
+// GenerateTextStream waits for room in the budget and streams a response
+func (l *Limiter) GenerateTextStream(ctx context.Context, writer io.Writer, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	r, err := l.reserve(ctx, estimateTokens(partsToString(parts)))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.Provider.GenerateTextStream(ctx, writer, parts...)
+	l.settle(r, resp)
+	return resp, err
+}
+
+// StartChat starts a chat session whose messages wait for room in the budget
+func (l *Limiter) StartChat() ChatSession {
+	return &limitedChatSession{ChatSession: l.Provider.StartChat(), limiter: l}
+}
+
+// SwitchModel switches to a different model, which has its own budget
+func (l *Limiter) SwitchModel(modelName string) error {
+	if err := l.Provider.SwitchModel(modelName); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.model = modelName
+	l.mu.Unlock()
+	return nil
+}
+
+// limitedChatSession makes the messages of a wrapped chat session wait for
+// room in a Limiter's budget. The whole history counts towards the tokens
+// of each message, since it's sent along with it.
+type limitedChatSession struct {
+	ChatSession
+	limiter *Limiter
+}
+
+// SendMessage waits for room in the budget and sends a message
+func (s *limitedChatSession) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	r, err := s.limiter.reserve(ctx, s.estimate(parts))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.ChatSession.SendMessage(ctx, parts...)
+	s.limiter.settle(r, resp)
+	return resp, err
+}
+
+// SendMessageStream waits for room in the budget and sends a message,
+// streaming the reply
+func (s *limitedChatSession) SendMessageStream(ctx context.Context, onChunk func(text string), parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	r, err := s.limiter.reserve(ctx, s.estimate(parts))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.ChatSession.SendMessageStream(ctx, onChunk, parts...)
+	s.limiter.settle(r, resp)
+	return resp, err
+}
+
+// estimate guesses the tokens a message takes with the history before it
+func (s *limitedChatSession) estimate(parts []genai.Part) int32 {
+	tokens := estimateTokens(partsToString(parts))
+	for _, c := range s.History() {
+		tokens += estimateTokens(partsToString(c.Parts))
+	}
+	return tokens
+}
+
+// rateState is the contents of the limiter's state file: the requests made
+// in the last rateWindow, by model
+type rateState struct {
+	Models map[string][]rateEvent `json:"models"`
+}
+
+// rateEvent is a request counted against a budget
+type rateEvent struct {
+	ID     string    `json:"id"`
+	At     time.Time `json:"at"`
+	Tokens int32     `json:"tokens"`
+}
+
+// reservation is a request's place in the budget
+type reservation struct {
+	id    string
+	model string
+}
+
+// reservationSeq numbers this process's reservations
+var reservationSeq atomic.Int64
+
+// reserve waits until the current model's budget has room for a request
+// of about tokens tokens, and counts it
+func (l *Limiter) reserve(ctx context.Context, tokens int32) (*reservation, error) {
+	if l.limit.Unlimited() {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	r := &reservation{id: fmt.Sprintf("%d-%d", os.Getpid(), reservationSeq.Add(1)), model: l.model}
+	l.mu.Unlock()
+
+	for {
+		var wait time.Duration
+		err := l.update(ctx, func(state *rateState) {
+			now := time.Now()
+			events := state.Models[r.model]
+			wait = l.limit.wait(events, tokens, now)
+			if wait == 0 {
+				state.Models[r.model] = append(events, rateEvent{ID: r.id, At: now, Tokens: tokens})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if wait == 0 {
+			return r, nil
+		}
+
+		if l.onWait != nil {
+			l.onWait(r.model, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// settle replaces the estimated tokens of a reservation with the usage
+// reported in resp, which for a stream that failed part way is the usage
+// of the part that arrived
+func (l *Limiter) settle(r *reservation, resp *genai.GenerateContentResponse) {
+	if r == nil || resp == nil || resp.UsageMetadata == nil {
+		return
+	}
+
+	// The request already happened, so don't let a cancelled context or
+	// a failure to update the state get in the way
+	l.update(context.Background(), func(state *rateState) {
+		events := state.Models[r.model]
+		for i := range events {
+			if events[i].ID == r.id {
+				events[i].Tokens = resp.UsageMetadata.TotalTokenCount
+			}
+		}
+	})
+}
+
+// wait returns how long until events leave room for another request of
+// tokens tokens, or 0 if there is room now
+func (l RateLimit) wait(events []rateEvent, tokens int32, now time.Time) time.Duration {
+	var wait time.Duration
+	until := func(e rateEvent) time.Duration {
+		return e.At.Add(rateWindow).Sub(now)
+	}
+
+	if l.RPM > 0 && len(events) >= l.RPM {
+		wait = max(wait, until(events[len(events)-l.RPM]))
+	}
+
+	if l.TPM > 0 {
+		var used int32
+		for _, e := range events {
+			used += e.Tokens
+		}
+		// Wait for the oldest requests to leave the window until the rest
+		// leave enough room. A request bigger than the whole budget only
+		// has to wait for an empty window.
+		for i := 0; i < len(events) && used+tokens > int32(l.TPM); i++ {
+			used -= events[i].Tokens
+			wait = max(wait, until(events[i]))
+		}
+	}
+
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// update applies fn to the state file while holding its lock, dropping
+// requests that have left the window
+func (l *Limiter) update(ctx context.Context, fn func(state *rateState)) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create rate limit directory: %v", err)
+	}
+	unlock, err := lockFile(ctx, l.path+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// A missing or damaged state file just means no recent requests
+	state := &rateState{}
+	if data, err := os.ReadFile(l.path); err == nil {
+		json.Unmarshal(data, state)
+	}
+	if state.Models == nil {
+		state.Models = make(map[string][]rateEvent)
+	}
+
+	cutoff := time.Now().Add(-rateWindow)
+	for model, events := range state.Models {
+		kept := events[:0]
+		for _, e := range events {
+			if e.At.After(cutoff) {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(state.Models, model)
+		} else {
+			state.Models[model] = kept
+		}
+	}
+
+	fn(state)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode rate limit state: %v", err)
+	}
+	if err := os.WriteFile(l.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save rate limit state: %v", err)
+	}
+	return nil
+}
+
+// estimateTokens estimates the number of tokens in text at about four
+// characters per token
+func estimateTokens(text string) int32 {
+	return int32((len([]rune(text)) + 3) / 4)
+}
+
+// lockFile takes an exclusive lock by creating path, waiting while another
+// process holds it. It returns a function that releases the lock.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock rate limit state: %v", err)
+		}
+
+		// Break a lock left behind by a process that died holding it
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(path)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestRateLimitWait(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration, tokens int32) rateEvent {
+		return rateEvent{At: now.Add(-d), Tokens: tokens}
+	}
+
+	tests := []struct {
+		name   string
+		limit  RateLimit
+		events []rateEvent
+		tokens int32
+		want   time.Duration
+	}{
+		{
+			name:   "unlimited",
+			events: []rateEvent{ago(time.Second, 1000), ago(0, 1000)},
+			tokens: 1000,
+		},
+		{
+			name:   "room for requests",
+			limit:  RateLimit{RPM: 3},
+			events: []rateEvent{ago(10*time.Second, 0), ago(5*time.Second, 0)},
+		},
+		{
+			name:   "requests full until the oldest leaves",
+			limit:  RateLimit{RPM: 2},
+			events: []rateEvent{ago(40*time.Second, 0), ago(10*time.Second, 0)},
+			want:   20 * time.Second,
+		},
+		{
+			name:   "only the last RPM requests count",
+			limit:  RateLimit{RPM: 2},
+			events: []rateEvent{ago(50*time.Second, 0), ago(30*time.Second, 0), ago(5*time.Second, 0)},
+			want:   30 * time.Second,
+		},
+		{
+			name:   "room for tokens",
+			limit:  RateLimit{TPM: 1000},
+			events: []rateEvent{ago(10*time.Second, 400)},
+			tokens: 600,
+		},
+		{
+			name:   "tokens full until enough leave",
+			limit:  RateLimit{TPM: 1000},
+			events: []rateEvent{ago(50*time.Second, 300), ago(30*time.Second, 300), ago(10*time.Second, 300)},
+			tokens: 500,
+			want:   30 * time.Second,
+		},
+		{
+			name:   "request bigger than the budget waits for an empty window",
+			limit:  RateLimit{TPM: 1000},
+			events: []rateEvent{ago(50*time.Second, 10), ago(20*time.Second, 10)},
+			tokens: 5000,
+			want:   40 * time.Second,
+		},
+		{
+			name:   "longest of both limits",
+			limit:  RateLimit{RPM: 2, TPM: 1000},
+			events: []rateEvent{ago(55*time.Second, 100), ago(40*time.Second, 100), ago(10*time.Second, 850)},
+			tokens: 200,
+			want:   50 * time.Second,
+		},
+		{
+			name:   "events already out of the window",
+			limit:  RateLimit{RPM: 1},
+			events: []rateEvent{ago(2*time.Minute, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limit.wait(tt.events, tt.tokens, now); got != tt.want {
+				t.Errorf("wait = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterSettlesStreams(t *testing.T) {
+	chunks := []*genai.GenerateContentResponse{usageChunk("The quick ", 12, 3), usageChunk("brown fox ", 12, 5), usageChunk("jumps.", 12, 8)}
+
+	tests := []struct {
+		name     string
+		provider func(t *testing.T) Provider
+		stream   func(p Provider) error
+		wantErr  bool
+	}{
+		{
+			name: "streamed generate",
+			provider: func(t *testing.T) Provider {
+				return &streamingProvider{FakeProvider: NewFakeProvider(nil, ""), chunks: chunks}
+			},
+			stream: func(p Provider) error {
+				_, err := p.GenerateTextStream(context.Background(), io.Discard, genai.Text("hi"))
+				return err
+			},
+		},
+		{
+			name: "chat cut off",
+			provider: func(t *testing.T) Provider {
+				return newCutOffClient(t, jsonChunk("The quick ", 12, 3), jsonChunk("brown fox jumps.", 12, 8))
+			},
+			stream: func(p Provider) error {
+				_, err := p.StartChat().SendMessageStream(context.Background(), func(string) {}, genai.Text("hi"))
+				return err
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			limiter := NewLimiter(tt.provider(t), "test-model", RateLimit{TPM: 1000}, dir, nil)
+			if err := tt.stream(limiter); (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "ratelimit.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var state rateState
+			if err := json.Unmarshal(data, &state); err != nil {
+				t.Fatal(err)
+			}
+			events := state.Models["test-model"]
+			if len(events) != 1 || events[0].Tokens != 20 {
+				t.Errorf("budget holds %+v, want one request of 20 tokens", events)
+			}
+		})
+	}
+}
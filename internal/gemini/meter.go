@@ -0,0 +1,90 @@
+package gemini
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Meter is a Provider that reports the token usage of every response from
+// another Provider, such as to keep a ledger of spend
+type Meter struct {
+	Provider
+
+	// onUsage is called with the model and usage of each response that
+	// reports its usage
+	onUsage func(model string, usage *genai.UsageMetadata)
+
+	mu    sync.Mutex
+	model string
+}
+
+// NewMeter wraps provider, which is using model, to report usage to onUsage
+func NewMeter(provider Provider, model string, onUsage func(model string, usage *genai.UsageMetadata)) *Meter {
+	return &Meter{Provider: provider, onUsage: onUsage, model: model}
+}
+
+// GenerateText generates a response and reports its usage
+func (m *Meter) GenerateText(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := m.Provider.GenerateText(ctx, parts...)
+	m.report(resp)
+	return resp, err
+}
+
+// GenerateTextStream streams a response and reports its usage, or that of
+// the part that arrived if the stream fails
+func (m *Meter) GenerateTextStream(ctx context.Context, writer io.Writer, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := m.Provider.GenerateTextStream(ctx, writer, parts...)
+	m.report(resp)
+	return resp, err
+}
+
+// StartChat starts a chat session whose replies have their usage reported
+func (m *Meter) StartChat() ChatSession {
+	return &meteredChatSession{ChatSession: m.Provider.StartChat(), meter: m}
+}
+
+// SwitchModel switches to a different model, which later usage is reported for
+func (m *Meter) SwitchModel(modelName string) error {
+	if err := m.Provider.SwitchModel(modelName); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.model = modelName
+	m.mu.Unlock()
+	return nil
+}
+
+// report passes the usage of resp to onUsage
+func (m *Meter) report(resp *genai.GenerateContentResponse) {
+	if resp == nil || resp.UsageMetadata == nil {
+		return
+	}
+	m.mu.Lock()
+	model := m.model
+	m.mu.Unlock()
+	m.onUsage(model, resp.UsageMetadata)
+}
+
+// meteredChatSession reports the usage of a wrapped chat session's replies
+type meteredChatSession struct {
+	ChatSession
+	meter *Meter
+}
+
+// SendMessage sends a message and reports the usage of the reply
+func (s *meteredChatSession) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := s.ChatSession.SendMessage(ctx, parts...)
+	s.meter.report(resp)
+	return resp, err
+}
+
+// SendMessageStream sends a message, streaming the reply, and reports its
+// usage, or that of the part that arrived if the stream fails
+func (s *meteredChatSession) SendMessageStream(ctx context.Context, onChunk func(text string), parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := s.ChatSession.SendMessageStream(ctx, onChunk, parts...)
+	s.meter.report(resp)
+	return resp, err
+}
@@ -0,0 +1,101 @@
+package gemini
+
+import (
+	"context"
+	"io"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Provider is a generative AI backend used by the gemi commands.
+// Client is the Gemini implementation.
+type Provider interface {
+	// GenerateText generates a response to the parts of a prompt
+	GenerateText(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error)
+
+	// GenerateTextStream generates a response to the parts of a prompt, streams
+	// its text to writer and returns the whole response. If the stream fails
+	// part way, the response returned with the error carries only the usage
+	// reported so far, or is nil if none was.
+	GenerateTextStream(ctx context.Context, writer io.Writer, parts ...genai.Part) (*genai.GenerateContentResponse, error)
+
+	// StartChat starts a new chat session
+	StartChat() ChatSession
+
+	// CountTokens counts the input tokens the parts of a prompt take with
+	// the current model and settings
+	CountTokens(ctx context.Context, parts ...genai.Part) (int32, error)
+
+	// ListModels lists all available models
+	ListModels(ctx context.Context) ([]*genai.ModelInfo, error)
+
+	// ModelInfo describes the current model, including its token limits.
+	// Limits the provider can't know are left at 0.
+	ModelInfo(ctx context.Context) (*genai.ModelInfo, error)
+
+	// SwitchModel switches to a different model
+	SwitchModel(modelName string) error
+
+	// Settings returns the current generation settings
+	Settings() Settings
+
+	// SetSettings replaces the generation settings. Existing chat sessions
+	// use the new settings for their next message.
+	SetSettings(settings Settings) error
+
+	// Close releases any resources held by the provider
+	Close() error
+}
+
+// ChatSession is a multi-turn conversation with a Provider
+type ChatSession interface {
+	// SendMessage sends a message and returns the model's response.
+	// On success both the message and the response are added to the history.
+	SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error)
+
+	// SendMessageStream sends a message and calls onChunk with each piece of
+	// the reply as it arrives, then returns the whole response. If the stream
+	// fails or ctx is cancelled part way, the history keeps the message with
+	// the partial reply; if nothing arrived, it keeps neither. The usage
+	// reported so far is returned with the error as for GenerateTextStream.
+	SendMessageStream(ctx context.Context, onChunk func(text string), parts ...genai.Part) (*genai.GenerateContentResponse, error)
+
+	// CountTokens counts the input tokens of the history followed by a
+	// message, which is what sending the message would take. With no
+	// parts it counts the history alone.
+	CountTokens(ctx context.Context, parts ...genai.Part) (int32, error)
+
+	// History returns the conversation so far
+	History() []*genai.Content
+
+	// SetHistory replaces the conversation history
+	SetHistory(history []*genai.Content)
+}
+
+// withPartialReply returns history extended with a message and the part of
+// its reply that arrived before a stream stopped, keeping the history in
+// user/model pairs. history is returned unchanged if nothing arrived.
+func withPartialReply(history []*genai.Content, parts []genai.Part, partial string) []*genai.Content {
+	if partial == "" {
+		return history
+	}
+	return append(history[:len(history):len(history)],
+		genai.NewUserContent(parts...),
+		&genai.Content{Role: "model", Parts: []genai.Part{genai.Text(partial)}},
+	)
+}
+
+// historyParts flattens the history followed by a message into the parts
+// of a single prompt, for counting tokens
+func historyParts(history []*genai.Content, parts []genai.Part) []genai.Part {
+	var all []genai.Part
+	for _, c := range history {
+		all = append(all, c.Parts...)
+	}
+	return append(all, parts...)
+}
+
+// ResponseText extracts the text parts from a GenerateContentResponse
+func ResponseText(resp *genai.GenerateContentResponse) string {
+	return responseToString(resp)
+}
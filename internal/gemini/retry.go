@@ -0,0 +1,303 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+)
+
+// RetryPolicy controls how requests that fail with a transient error, such
+// as a 429 or 503, are retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts per request, including the
+	// first. 1 disables retries.
+	MaxAttempts int
+
+	// MaxElapsed stops retrying once the next attempt would start this long
+	// after the first. 0 means no limit.
+	MaxElapsed time.Duration
+
+	// InitialDelay is the delay before the first retry. It doubles with
+	// each retry, up to MaxDelay, and is jittered.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+
+	// OnRetry, if set, is called before waiting to retry a failed attempt
+	OnRetry func(err error, attempt int, delay time.Duration)
+}
+
+// DefaultRetryPolicy is used unless flags or the configuration say otherwise
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  4,
+	MaxElapsed:   2 * time.Minute,
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+}
+
+// do calls attempt until it succeeds, fails with an error that isn't worth
+// retrying, or the policy's budget runs out
+func (p RetryPolicy) do(ctx context.Context, attempt func() error) error {
+	start := time.Now()
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		// A request cut short by Ctrl+C or a timeout fails because of that,
+		// whatever the attempt reported
+		if ctx.Err() != nil {
+			return contextError(ctx, err)
+		}
+		if !retryable(err) {
+			return err
+		}
+		if n >= p.MaxAttempts {
+			if n > 1 {
+				return fmt.Errorf("%w (gave up after %d attempts)", err, n)
+			}
+			return err
+		}
+
+		delay := p.delay(n, err)
+		if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+			return fmt.Errorf("%w (gave up after %d attempts)", err, n)
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(err, n, delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return contextError(ctx, err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// contextError reports that ctx ended a request whose last attempt failed
+// with err
+func contextError(ctx context.Context, err error) error {
+	if errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+}
+
+// delay returns how long to wait after the nth failed attempt: the server's
+// retry hint if it gave one, otherwise an exponential backoff with jitter
+func (p RetryPolicy) delay(n int, err error) time.Duration {
+	if hint, ok := retryAfter(err); ok {
+		return hint
+	}
+
+	backoff := p.InitialDelay << (n - 1)
+	if backoff > p.MaxDelay || backoff <= 0 {
+		backoff = p.MaxDelay
+	}
+	// Wait between half and all of the backoff so that clients failing
+	// together don't retry together
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// retryable reports whether err is a transient failure worth retrying
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.HTTPCode() {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+			http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter returns the delay the server asked for before retrying, from
+// the error's RetryInfo details or a Retry-After header
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) {
+		if info := apiErr.Details().RetryInfo; info != nil && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+
+	var httpErr *googleapi.Error
+	if errors.As(err, &httpErr) && httpErr.Header != nil {
+		value := httpErr.Header.Get("Retry-After")
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return max(0, time.Until(at)), true
+		}
+	}
+	return 0, false
+}
+
+// Retrier is a Provider that retries the transient failures of another
+// Provider. Streams are only retried while nothing has been streamed yet.
+type Retrier struct {
+	Provider
+	policy RetryPolicy
+}
+
+// NewRetrier wraps provider to retry failed requests according to policy
+func NewRetrier(provider Provider, policy RetryPolicy) *Retrier {
+	return &Retrier{Provider: provider, policy: policy}
+}
+
+// GenerateText generates a response, retrying transient failures
+func (r *Retrier) GenerateText(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	var resp *genai.GenerateContentResponse
+	err := r.policy.do(ctx, func() (err error) {
+		resp, err = r.Provider.GenerateText(ctx, parts...)
+		return err
+	})
+	return resp, err
+}
+
+// GenerateTextStream streams a response, retrying transient failures that
+// happen before anything has been written to writer
+func (r *Retrier) GenerateTextStream(ctx context.Context, writer io.Writer, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	w := &emitWriter{writer: writer}
+	var resp *genai.GenerateContentResponse
+	err := r.policy.do(ctx, func() (err error) {
+		resp, err = r.Provider.GenerateTextStream(ctx, w, parts...)
+		if err != nil && w.emitted {
+			return noRetry{err}
+		}
+		return err
+	})
+	return resp, unwrapNoRetry(err)
+}
+
+// StartChat starts a chat session whose messages are retried
+func (r *Retrier) StartChat() ChatSession {
+	return &retryingChatSession{ChatSession: r.Provider.StartChat(), policy: r.policy}
+}
+
+// CountTokens counts the input tokens of a prompt, retrying transient
+// failures
+func (r *Retrier) CountTokens(ctx context.Context, parts ...genai.Part) (int32, error) {
+	var tokens int32
+	err := r.policy.do(ctx, func() (err error) {
+		tokens, err = r.Provider.CountTokens(ctx, parts...)
+		return err
+	})
+	return tokens, err
+}
+
+// ModelInfo describes the current model, retrying transient failures
+func (r *Retrier) ModelInfo(ctx context.Context) (*genai.ModelInfo, error) {
+	var info *genai.ModelInfo
+	err := r.policy.do(ctx, func() (err error) {
+		info, err = r.Provider.ModelInfo(ctx)
+		return err
+	})
+	return info, err
+}
+
+// ListModels lists the available models, retrying transient failures
+func (r *Retrier) ListModels(ctx context.Context) ([]*genai.ModelInfo, error) {
+	var models []*genai.ModelInfo
+	err := r.policy.do(ctx, func() (err error) {
+		models, err = r.Provider.ListModels(ctx)
+		return err
+	})
+	return models, err
+}
+
+// retryingChatSession retries the messages of a wrapped chat session. The
+// wrapped session leaves its history unchanged when a message fails
+// without a reply, so the message can be sent again.
+type retryingChatSession struct {
+	ChatSession
+	policy RetryPolicy
+}
+
+// SendMessage sends a message, retrying transient failures
+func (s *retryingChatSession) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	var resp *genai.GenerateContentResponse
+	err := s.policy.do(ctx, func() (err error) {
+		resp, err = s.ChatSession.SendMessage(ctx, parts...)
+		return err
+	})
+	return resp, err
+}
+
+// SendMessageStream sends a message, retrying transient failures that
+// happen before any of the reply has arrived
+func (s *retryingChatSession) SendMessageStream(ctx context.Context, onChunk func(text string), parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	emitted := false
+	var resp *genai.GenerateContentResponse
+	err := s.policy.do(ctx, func() (err error) {
+		resp, err = s.ChatSession.SendMessageStream(ctx, func(text string) {
+			emitted = true
+			onChunk(text)
+		}, parts...)
+		if err != nil && emitted {
+			return noRetry{err}
+		}
+		return err
+	})
+	return resp, unwrapNoRetry(err)
+}
+
+// CountTokens counts the input tokens of the history and a message,
+// retrying transient failures
+func (s *retryingChatSession) CountTokens(ctx context.Context, parts ...genai.Part) (int32, error) {
+	var tokens int32
+	err := s.policy.do(ctx, func() (err error) {
+		tokens, err = s.ChatSession.CountTokens(ctx, parts...)
+		return err
+	})
+	return tokens, err
+}
+
+// emitWriter notes whether anything has been written through it
+type emitWriter struct {
+	writer  io.Writer
+	emitted bool
+}
+
+func (w *emitWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.emitted = true
+	}
+	return w.writer.Write(p)
+}
+
+// noRetry stops a retryable error from being retried
+type noRetry struct {
+	err error
+}
+
+func (e noRetry) Error() string {
+	return e.err.Error()
+}
+
+// unwrapNoRetry returns the error inside a noRetry
+func unwrapNoRetry(err error) error {
+	if e, ok := err.(noRetry); ok {
+		return e.err
+	}
+	return err
+}
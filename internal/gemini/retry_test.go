@@ -0,0 +1,207 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDo(t *testing.T) {
+	unavailable := fakeError(FakeResponse{Error: "overloaded", Status: 503})
+	badRequest := fakeError(FakeResponse{Error: "bad request", Status: 400})
+
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		errs     []error // returned by successive attempts; nil after the last
+		want     error
+		wantText string
+		attempts int
+	}{
+		{
+			name:     "success",
+			policy:   RetryPolicy{MaxAttempts: 4},
+			attempts: 1,
+		},
+		{
+			name:     "transient failures then success",
+			policy:   RetryPolicy{MaxAttempts: 4},
+			errs:     []error{unavailable, io.ErrUnexpectedEOF},
+			attempts: 3,
+		},
+		{
+			name:     "permanent failure",
+			policy:   RetryPolicy{MaxAttempts: 4},
+			errs:     []error{badRequest},
+			want:     badRequest,
+			attempts: 1,
+		},
+		{
+			name:     "out of attempts",
+			policy:   RetryPolicy{MaxAttempts: 3},
+			errs:     []error{unavailable, unavailable, unavailable, unavailable},
+			want:     unavailable,
+			wantText: "gave up after 3 attempts",
+			attempts: 3,
+		},
+		{
+			name:     "retries disabled",
+			policy:   RetryPolicy{MaxAttempts: 1},
+			errs:     []error{unavailable},
+			want:     unavailable,
+			attempts: 1,
+		},
+		{
+			name:     "out of time",
+			policy:   RetryPolicy{MaxAttempts: 10, MaxElapsed: time.Millisecond, InitialDelay: time.Second, MaxDelay: time.Second},
+			errs:     []error{unavailable, unavailable},
+			want:     unavailable,
+			wantText: "gave up after 1 attempts",
+			attempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.policy.InitialDelay == 0 {
+				tt.policy.InitialDelay, tt.policy.MaxDelay = time.Millisecond, time.Millisecond
+			}
+			attempts := 0
+			err := tt.policy.do(context.Background(), func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+
+			if tt.want == nil && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+			if tt.wantText != "" && (err == nil || !strings.Contains(err.Error(), tt.wantText)) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.wantText)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("made %d attempts, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoCancelled(t *testing.T) {
+	unavailable := fakeError(FakeResponse{Error: "overloaded", Status: 503})
+	policy := RetryPolicy{MaxAttempts: 4, InitialDelay: time.Hour, MaxDelay: time.Hour}
+
+	tests := []struct {
+		name string
+		// ctx returns the context of the request and a function ending it
+		ctx     func() (context.Context, func())
+		attempt func(end func()) error
+		want    error
+	}{
+		{
+			name: "cancelled while waiting to retry",
+			ctx: func() (context.Context, func()) {
+				return context.WithCancel(context.Background())
+			},
+			attempt: func(end func()) error {
+				time.AfterFunc(10*time.Millisecond, end)
+				return unavailable
+			},
+			want: context.Canceled,
+		},
+		{
+			name: "timed out while waiting to retry",
+			ctx: func() (context.Context, func()) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			attempt: func(end func()) error {
+				return unavailable
+			},
+			want: context.DeadlineExceeded,
+		},
+		{
+			name: "cancelled during a failed attempt",
+			ctx: func() (context.Context, func()) {
+				return context.WithCancel(context.Background())
+			},
+			attempt: func(end func()) error {
+				end()
+				return fmt.Errorf("stream broke: %w", io.ErrUnexpectedEOF)
+			},
+			want: context.Canceled,
+		},
+		{
+			name: "attempt reports the cancellation itself",
+			ctx: func() (context.Context, func()) {
+				return context.WithCancel(context.Background())
+			},
+			attempt: func(end func()) error {
+				end()
+				return fmt.Errorf("failed to get next response: %w", context.Canceled)
+			},
+			want: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, end := tt.ctx()
+			defer end()
+
+			attempts := 0
+			done := make(chan error, 1)
+			go func() {
+				done <- policy.do(ctx, func() error {
+					attempts++
+					return tt.attempt(end)
+				})
+			}()
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, tt.want) {
+					t.Errorf("error = %v, want %v", err, tt.want)
+				}
+				if attempts != 1 {
+					t.Errorf("made %d attempts, want 1", attempts)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("do didn't return once the context ended")
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", fakeError(FakeResponse{Error: "quota", Status: 429}), true},
+		{"unavailable", fakeError(FakeResponse{Error: "overloaded", Status: 503}), true},
+		{"wrapped server error", fmt.Errorf("failed: %w", fakeError(FakeResponse{Error: "oops", Status: 500})), true},
+		{"bad request", fakeError(FakeResponse{Error: "bad", Status: 400}), false},
+		{"forbidden", fakeError(FakeResponse{Error: "no", Status: 403}), false},
+		{"cut-off stream", io.ErrUnexpectedEOF, true},
+		{"cancelled", context.Canceled, false},
+		{"timed out", fmt.Errorf("failed: %w", context.DeadlineExceeded), false},
+		{"plain error", errors.New("something"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
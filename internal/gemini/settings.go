@@ -0,0 +1,126 @@
+package gemini
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Settings control how a Provider generates content. Nil fields use the
+// provider's defaults.
+type Settings struct {
+	// Temperature controls randomness, from 0 to 2
+	Temperature *float32
+
+	// TopP is the cumulative probability cutoff for nucleus sampling
+	TopP *float32
+
+	// TopK is the number of most likely tokens considered at each step
+	TopK *int32
+
+	// MaxOutputTokens caps the length of a response
+	MaxOutputTokens *int32
+
+	// CandidateCount is the number of responses to generate.
+	// Chat sessions always use one.
+	CandidateCount *int32
+
+	// StopSequences end generation when produced
+	StopSequences []string
+
+	// SystemInstruction is sent ahead of every prompt and conversation
+	SystemInstruction string
+}
+
+// SettingKeys lists the keys accepted by Settings.Set
+var SettingKeys = []string{"temperature", "top_p", "top_k", "max_tokens", "candidates", "stop"}
+
+// Set parses value and assigns it to the setting named key. An empty value
+// or "default" resets the setting.
+func (s *Settings) Set(key string, value string) error {
+	reset := value == "" || value == "default"
+
+	switch key {
+	case "temperature":
+		return setFloat(&s.Temperature, key, value, reset, 0, 2)
+	case "top_p":
+		return setFloat(&s.TopP, key, value, reset, 0, 1)
+	case "top_k":
+		return setInt(&s.TopK, key, value, reset, 1)
+	case "max_tokens":
+		return setInt(&s.MaxOutputTokens, key, value, reset, 1)
+	case "candidates":
+		return setInt(&s.CandidateCount, key, value, reset, 1)
+	case "stop":
+		s.StopSequences = nil
+		if !reset {
+			s.StopSequences = strings.Split(value, ",")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown setting %q (valid settings: %s)", key, strings.Join(SettingKeys, ", "))
+	}
+}
+
+// String describes the settings that differ from the defaults
+func (s Settings) String() string {
+	var parts []string
+	if s.Temperature != nil {
+		parts = append(parts, "temperature="+strconv.FormatFloat(float64(*s.Temperature), 'g', -1, 32))
+	}
+	if s.TopP != nil {
+		parts = append(parts, "top_p="+strconv.FormatFloat(float64(*s.TopP), 'g', -1, 32))
+	}
+	if s.TopK != nil {
+		parts = append(parts, "top_k="+strconv.Itoa(int(*s.TopK)))
+	}
+	if s.MaxOutputTokens != nil {
+		parts = append(parts, "max_tokens="+strconv.Itoa(int(*s.MaxOutputTokens)))
+	}
+	if s.CandidateCount != nil {
+		parts = append(parts, "candidates="+strconv.Itoa(int(*s.CandidateCount)))
+	}
+	if len(s.StopSequences) > 0 {
+		parts = append(parts, "stop="+strings.Join(s.StopSequences, ","))
+	}
+	if len(parts) == 0 {
+		return "defaults"
+	}
+	return strings.Join(parts, " ")
+}
+
+func setFloat(dst **float32, key string, value string, reset bool, lo float64, hi float64) error {
+	if reset {
+		*dst = nil
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", key, value, err)
+	}
+	if f < lo || f > hi {
+		return fmt.Errorf("%s must be between %g and %g", key, lo, hi)
+	}
+	v := float32(f)
+	*dst = &v
+	return nil
+}
+
+func setInt(dst **int32, key string, value string, reset bool, lo int64) error {
+	if reset {
+		*dst = nil
+		return nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", key, value, err)
+	}
+	if n < lo {
+		return fmt.Errorf("%s must be at least %d", key, lo)
+	}
+	v := int32(n)
+	*dst = &v
+	return nil
+}
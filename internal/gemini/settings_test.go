@@ -0,0 +1,60 @@
+package gemini
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSettingsSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   Settings
+		key     string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{name: "temperature", key: "temperature", value: "0.7", want: "temperature=0.7"},
+		{name: "temperature bounds", key: "temperature", value: "2", want: "temperature=2"},
+		{name: "temperature too high", key: "temperature", value: "2.5", wantErr: "temperature must be between 0 and 2"},
+		{name: "temperature negative", key: "temperature", value: "-1", wantErr: "temperature must be between 0 and 2"},
+		{name: "temperature not a number", key: "temperature", value: "warm", wantErr: `invalid temperature "warm"`},
+		{name: "top_p", key: "top_p", value: "0.9", want: "top_p=0.9"},
+		{name: "top_p too high", key: "top_p", value: "1.5", wantErr: "top_p must be between 0 and 1"},
+		{name: "top_k", key: "top_k", value: "40", want: "top_k=40"},
+		{name: "top_k too low", key: "top_k", value: "0", wantErr: "top_k must be at least 1"},
+		{name: "max_tokens", key: "max_tokens", value: "256", want: "max_tokens=256"},
+		{name: "max_tokens not an integer", key: "max_tokens", value: "1.5", wantErr: `invalid max_tokens "1.5"`},
+		{name: "candidates", key: "candidates", value: "2", want: "candidates=2"},
+		{name: "stop", key: "stop", value: "END,STOP", want: "stop=END,STOP"},
+		{name: "reset with default", start: Settings{Temperature: ptr[float32](1)}, key: "temperature", value: "default", want: "defaults"},
+		{name: "reset with empty", start: Settings{StopSequences: []string{"x"}}, key: "stop", value: "", want: "defaults"},
+		{name: "unknown key", key: "warmth", value: "1", wantErr: `unknown setting "warmth"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.start
+			err := s.Set(tt.key, tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				if s.String() != tt.start.String() {
+					t.Errorf("failed Set changed the settings to %s", s.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := s.String(); got != tt.want {
+				t.Errorf("settings = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,239 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Export formats
+const (
+	FormatMarkdown = "md"
+	FormatJSON     = "json"
+	FormatHTML     = "html"
+	FormatJSONL    = "jsonl"
+)
+
+// Formats lists the supported export formats
+var Formats = []string{FormatMarkdown, FormatJSON, FormatHTML, FormatJSONL}
+
+// FormatFromPath picks an export format from a file extension, defaulting
+// to Markdown
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".html", ".htm":
+		return FormatHTML
+	case ".jsonl":
+		return FormatJSONL
+	default:
+		return FormatMarkdown
+	}
+}
+
+// Export renders the session in format
+func Export(s *Session, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatMarkdown:
+		buf.WriteString(strings.TrimRight(s.Markdown(), "\n") + "\n")
+	case FormatJSON:
+		err = exportJSON(&buf, s)
+	case FormatHTML:
+		err = exportHTML(&buf, s)
+	case FormatJSONL:
+		err = exportJSONL(&buf, s)
+	default:
+		return nil, fmt.Errorf("unknown export format %q (use %s)", format, strings.Join(Formats, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exportJSON writes the session in its saved form, which keeps roles, parts,
+// model, token usage and timestamps
+func exportJSON(buf *bytes.Buffer, s *Session) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// tuningExample is one conversation in the Gemini supervised tuning format
+type tuningExample struct {
+	SystemInstruction *tuningContent  `json:"systemInstruction,omitempty"`
+	Contents          []tuningContent `json:"contents"`
+}
+
+type tuningContent struct {
+	Role  string       `json:"role"`
+	Parts []tuningPart `json:"parts"`
+}
+
+type tuningPart struct {
+	Text string `json:"text"`
+}
+
+// exportJSONL writes the conversation as one tuning example. Only text parts
+// are kept, and a trailing user turn without a reply is dropped.
+func exportJSONL(buf *bytes.Buffer, s *Session) error {
+	var example tuningExample
+	if s.SystemInstruction != "" {
+		example.SystemInstruction = &tuningContent{Role: "system", Parts: []tuningPart{{Text: s.SystemInstruction}}}
+	}
+
+	for _, c := range s.History {
+		content := tuningContent{Role: c.Role}
+		for _, part := range c.Parts {
+			if part.MIMEType == "" && part.Text != "" {
+				content.Parts = append(content.Parts, tuningPart{Text: part.Text})
+			}
+		}
+		if len(content.Parts) > 0 {
+			example.Contents = append(example.Contents, content)
+		}
+	}
+	if n := len(example.Contents); n > 0 && example.Contents[n-1].Role != RoleModel {
+		example.Contents = example.Contents[:n-1]
+	}
+	if len(example.Contents) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(example)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// htmlStyle is the chroma style used for code blocks in HTML exports
+const htmlStyle = "github"
+
+// exportHTML writes a self-contained page with Markdown rendered and code
+// highlighted, and its styles embedded
+func exportHTML(buf *bytes.Buffer, s *Session) error {
+	style := styles.Get(htmlStyle)
+	formatter := chromahtml.New(chromahtml.WithClasses(true))
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(renderer.WithNodeRenderers(
+			util.Prioritized(&codeRenderer{formatter: formatter, style: style}, 100),
+		)),
+	)
+
+	var css bytes.Buffer
+	if err := formatter.WriteCSS(&css, style); err != nil {
+		return fmt.Errorf("failed to render styles: %v", err)
+	}
+
+	title := html.EscapeString(s.Title)
+	if title == "" {
+		title = html.EscapeString(s.ID)
+	}
+
+	fmt.Fprintf(buf, "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>\n%s%s</style>\n</head>\n<body>\n", title, pageCSS, css.String())
+	fmt.Fprintf(buf, "<header>\n<h1>%s</h1>\n<p class=\"meta\">%s · %s", title, html.EscapeString(s.Model), s.CreatedAt.Local().Format("2006-01-02 15:04"))
+	if usage := s.Usage(); usage.TotalTokens > 0 {
+		fmt.Fprintf(buf, " · %d tokens", usage.TotalTokens)
+	}
+	buf.WriteString("</p>\n")
+	if s.SystemInstruction != "" {
+		fmt.Fprintf(buf, "<p class=\"system\"><strong>System:</strong> %s</p>\n", html.EscapeString(s.SystemInstruction))
+	}
+	buf.WriteString("</header>\n")
+
+	for _, m := range s.Messages {
+		switch m.Role {
+		case RoleUser:
+			fmt.Fprintf(buf, "<section class=\"user\">\n<div class=\"role\">You</div>\n<div class=\"text\">%s</div>\n</section>\n", html.EscapeString(m.Content))
+		case RoleModel:
+			buf.WriteString("<section class=\"model\">\n<div class=\"role\">Gemini</div>\n")
+			if err := md.Convert([]byte(m.Content), buf); err != nil {
+				return fmt.Errorf("failed to render message: %v", err)
+			}
+			buf.WriteString("</section>\n")
+		case RoleNote:
+			fmt.Fprintf(buf, "<section class=\"note\">%s</section>\n", html.EscapeString(m.Content))
+		}
+	}
+
+	buf.WriteString("</body>\n</html>\n")
+	return nil
+}
+
+// pageCSS lays out HTML exports
+const pageCSS = `body { max-width: 50rem; margin: 2rem auto; padding: 0 1rem; font-family: system-ui, sans-serif; line-height: 1.5; color: #1f2328; }
+header { border-bottom: 1px solid #d0d7de; margin-bottom: 1.5rem; }
+.meta, .note { color: #656d76; font-size: 0.9rem; }
+section { margin: 1.25rem 0; }
+.role { font-weight: bold; color: #7D56F4; }
+.model .role { color: #5F9EF3; }
+.user .text { white-space: pre-wrap; }
+pre { padding: 0.75rem; overflow-x: auto; border-radius: 6px; background: #f6f8fa; }
+code { font-family: ui-monospace, monospace; font-size: 0.9em; }
+`
+
+// codeRenderer renders fenced code blocks with chroma syntax highlighting
+type codeRenderer struct {
+	formatter *chromahtml.Formatter
+	style     *chroma.Style
+}
+
+// RegisterFuncs implements renderer.NodeRenderer
+func (r *codeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	block := node.(*ast.FencedCodeBlock)
+	var code strings.Builder
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		code.Write(segment.Value(source))
+	}
+
+	lexer := lexers.Get(string(block.Language(source)))
+	if lexer == nil {
+		lexer = lexers.Analyse(code.String())
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	if err := r.formatter.Format(w, r.style, iterator); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, nil
+}
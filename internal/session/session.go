@@ -0,0 +1,424 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Message roles in a transcript
+const (
+	RoleUser  = "user"
+	RoleModel = "model"
+	RoleNote  = "note"
+)
+
+// Kinds of note, telling the chat view how to show them
+const (
+	KindCommand = "command" // a slash command as typed
+	KindOutput  = "output"  // what a slash command printed
+)
+
+// Session is a saved chat conversation
+type Session struct {
+	ID                string    `json:"id"`
+	Title             string    `json:"title"`
+	Model             string    `json:"model"`
+	SystemInstruction string    `json:"system_instruction,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+
+	// Messages is the transcript as shown in the chat view
+	Messages []Message `json:"messages"`
+
+	// History is the conversation sent to the model
+	History []Content `json:"history"`
+}
+
+// Message is one entry of the transcript
+type Message struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+
+	// Kind is KindCommand or KindOutput for notes recording slash commands,
+	// and empty otherwise
+	Kind string `json:"kind,omitempty"`
+
+	// Usage is the token usage reported for a model reply
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// Usage counts the tokens of one request
+type Usage struct {
+	PromptTokens     int32 `json:"prompt_tokens"`
+	CandidatesTokens int32 `json:"candidates_tokens"`
+	TotalTokens      int32 `json:"total_tokens"`
+}
+
+// UsageFrom converts genai usage metadata, which may be nil
+func UsageFrom(metadata *genai.UsageMetadata) *Usage {
+	if metadata == nil {
+		return nil
+	}
+	return &Usage{
+		PromptTokens:     metadata.PromptTokenCount,
+		CandidatesTokens: metadata.CandidatesTokenCount,
+		TotalTokens:      metadata.TotalTokenCount,
+	}
+}
+
+// Content is a serializable genai.Content
+type Content struct {
+	Role  string `json:"role"`
+	Parts []Part `json:"parts"`
+}
+
+// Part is a serializable text or blob part
+type Part struct {
+	Text     string `json:"text,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+// New creates an empty session
+func New(model string, systemInstruction string) *Session {
+	now := time.Now()
+	return &Session{
+		ID:                newID(now),
+		Model:             model,
+		SystemInstruction: systemInstruction,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+}
+
+// newID returns a sortable, readable session ID
+func newID(now time.Time) string {
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// SetHistory stores a genai chat history in the session
+func (s *Session) SetHistory(history []*genai.Content) {
+	s.History = FromGenai(history)
+}
+
+// GenaiHistory returns the session history as genai contents
+func (s *Session) GenaiHistory() []*genai.Content {
+	return ToGenai(s.History)
+}
+
+// Touch updates the timestamp and, if unset, derives the title from the
+// first user message that isn't a command
+func (s *Session) Touch() {
+	s.UpdatedAt = time.Now()
+	if s.Title != "" {
+		return
+	}
+	for _, m := range s.Messages {
+		if m.Role == RoleUser && !strings.HasPrefix(m.Content, "/") {
+			s.Title = titleFrom(m.Content)
+			return
+		}
+	}
+}
+
+// titleFrom shortens a message to a one-line title
+func titleFrom(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) > 60 {
+		return string(runes[:59]) + "…"
+	}
+	return text
+}
+
+// FromGenai converts genai contents to their serializable form. Parts other
+// than text and blobs are dropped.
+func FromGenai(history []*genai.Content) []Content {
+	contents := make([]Content, 0, len(history))
+	for _, c := range history {
+		if c == nil {
+			continue
+		}
+		content := Content{Role: c.Role}
+		for _, part := range c.Parts {
+			switch part := part.(type) {
+			case genai.Text:
+				content.Parts = append(content.Parts, Part{Text: string(part)})
+			case genai.Blob:
+				content.Parts = append(content.Parts, Part{MIMEType: part.MIMEType, Data: part.Data})
+			}
+		}
+		contents = append(contents, content)
+	}
+	return contents
+}
+
+// ToGenai converts serialized contents back to genai contents
+func ToGenai(contents []Content) []*genai.Content {
+	history := make([]*genai.Content, 0, len(contents))
+	for _, c := range contents {
+		content := &genai.Content{Role: c.Role}
+		for _, part := range c.Parts {
+			if part.MIMEType != "" {
+				content.Parts = append(content.Parts, genai.Blob{MIMEType: part.MIMEType, Data: part.Data})
+			} else {
+				content.Parts = append(content.Parts, genai.Text(part.Text))
+			}
+		}
+		history = append(history, content)
+	}
+	return history
+}
+
+// Dir returns the directory sessions are stored in:
+// $XDG_DATA_HOME/gemi/sessions, or ~/.local/share/gemi/sessions
+func Dir() (string, error) {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate data directory: %v", err)
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(data, "gemi", "sessions"), nil
+}
+
+// path returns the file a session is stored in
+func path(id string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id+".json"), nil
+}
+
+// Save writes the session to disk
+func Save(s *Session) error {
+	p, err := path(s.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return fmt.Errorf("failed to create session directory: %v", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn session
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save session: %v", err)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return fmt.Errorf("failed to save session: %v", err)
+	}
+	return nil
+}
+
+// Load reads the session with the given ID
+func Load(id string) (*Session, error) {
+	p, err := path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no session with ID %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %v", id, err)
+	}
+	return &s, nil
+}
+
+// List returns all saved sessions, most recently active first
+func List() ([]*Session, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+
+	var sessions []*Session
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		s, err := Load(id)
+		if err != nil {
+			// Skip unreadable files rather than hiding every other session
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+// Resolve finds a session by ID, unique ID prefix, or "last" for the most
+// recently active one
+func Resolve(ref string) (*Session, error) {
+	sessions, err := List()
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no saved sessions")
+	}
+	if ref == "last" {
+		return sessions[0], nil
+	}
+
+	var matches []*Session
+	for _, s := range sessions {
+		if s.ID == ref {
+			return s, nil
+		}
+		if strings.HasPrefix(s.ID, ref) {
+			matches = append(matches, s)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no session with ID %s", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("session ID %s is ambiguous (%d matches)", ref, len(matches))
+	}
+}
+
+// Delete removes a saved session
+func Delete(id string) error {
+	p, err := path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no session with ID %s", id)
+		}
+		return fmt.Errorf("failed to delete session: %v", err)
+	}
+	return nil
+}
+
+// Prune deletes sessions last active before cutoff and returns them
+func Prune(cutoff time.Time) ([]*Session, error) {
+	sessions, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []*Session
+	for _, s := range sessions {
+		if !s.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		if err := Delete(s.ID); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, s)
+	}
+	return pruned, nil
+}
+
+// Match is a transcript message containing a search query
+type Match struct {
+	Session *Session
+	Message Message
+}
+
+// Search returns the messages of all sessions containing query, ignoring
+// case, most recently active sessions first
+func Search(query string) ([]Match, error) {
+	sessions, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	needle := strings.ToLower(query)
+	var matches []Match
+	for _, s := range sessions {
+		for _, m := range s.Messages {
+			if strings.Contains(strings.ToLower(m.Content), needle) {
+				matches = append(matches, Match{Session: s, Message: m})
+			}
+		}
+	}
+	return matches, nil
+}
+
+// MessageCount returns the number of user and model messages
+func (s *Session) MessageCount() int {
+	n := 0
+	for _, m := range s.Messages {
+		if m.Role != RoleNote {
+			n++
+		}
+	}
+	return n
+}
+
+// Usage sums the token usage of all model replies
+func (s *Session) Usage() Usage {
+	var total Usage
+	for _, m := range s.Messages {
+		if m.Usage != nil {
+			total.PromptTokens += m.Usage.PromptTokens
+			total.CandidatesTokens += m.Usage.CandidatesTokens
+			total.TotalTokens += m.Usage.TotalTokens
+		}
+	}
+	return total
+}
+
+// Markdown renders the transcript in the "You:"/"Gemini:" layout
+func (s *Session) Markdown() string {
+	var sb strings.Builder
+	for _, m := range s.Messages {
+		switch m.Role {
+		case RoleUser:
+			sb.WriteString("You: " + m.Content + "\n\n")
+		case RoleModel:
+			sb.WriteString("Gemini:\n\n" + m.Content + "\n\n")
+		case RoleNote:
+			sb.WriteString("> " + m.Content + "\n\n")
+		}
+	}
+	return sb.String()
+}
@@ -3,6 +3,7 @@ package ui
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	"github.com/charmbracelet/glamour"
 )
@@ -10,22 +11,47 @@ import (
 // Default style to use when rendering markdown
 const DefaultStyle = "dark"
 
+// configuredStyle is the style set with SetStyle
+var configuredStyle string
+
+// SetStyle sets the glamour style name or style file path used for rendering.
+// The GLAMOUR_STYLE environment variable takes precedence over it.
+func SetStyle(style string) {
+	configuredStyle = style
+}
+
+// DefaultWordWrap is the width markdown is wrapped to when the terminal
+// width isn't known
+const DefaultWordWrap = 100
+
 // RenderMarkdownWithGlamour renders markdown text using Glamour
 func RenderMarkdownWithGlamour(markdown string) (string, error) {
-	// Check if a style is set in the environment
-	style := os.Getenv("GLAMOUR_STYLE")
+	return RenderMarkdownWidth(markdown, DefaultWordWrap)
+}
+
+// RenderMarkdownWidth renders markdown text using Glamour, wrapped to width
+func RenderMarkdownWidth(markdown string, width int) (string, error) {
+	return renderMarkdown(markdown, currentStyle(), width)
+}
+
+// RenderMarkdownANSI renders markdown text using Glamour with ANSI styling
+// even when stdout isn't a terminal, for saving rendered output to a file
+func RenderMarkdownANSI(markdown string) (string, error) {
+	style := currentStyle()
 	if style == "" {
 		style = DefaultStyle
 	}
+	return renderMarkdown(markdown, style, DefaultWordWrap)
+}
 
-	// Create a renderer with the specified style
-	r, err := glamour.NewTermRenderer(
-		glamour.WithAutoStyle(),   // Automatically detect terminal style
-		glamour.WithWordWrap(100), // Wrap at 100 characters
-	)
+// renderMarkdown renders markdown text with a shared renderer
+func renderMarkdown(markdown string, style string, width int) (string, error) {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
 
+	r, err := renderer(style, width)
 	if err != nil {
-		return "", fmt.Errorf("failed to create markdown renderer: %v", err)
+		return "", err
 	}
 
 	// Render the markdown
@@ -37,6 +63,86 @@ func RenderMarkdownWithGlamour(markdown string) (string, error) {
 	return rendered, nil
 }
 
+// rendererKey identifies a shared renderer
+type rendererKey struct {
+	style string
+	width int
+}
+
+var (
+	// renderersMu guards renderers and serializes rendering with them
+	renderersMu sync.Mutex
+	renderers   = make(map[rendererKey]*glamour.TermRenderer)
+)
+
+// currentStyle returns the style set in the environment or the
+// configuration, or "" to detect one from the terminal
+func currentStyle() string {
+	if style := os.Getenv("GLAMOUR_STYLE"); style != "" {
+		return style
+	}
+	return configuredStyle
+}
+
+// renderer returns the shared renderer for style and width, creating it on
+// first use. Callers must hold renderersMu.
+func renderer(style string, width int) (*glamour.TermRenderer, error) {
+	key := rendererKey{style: style, width: width}
+	if r, ok := renderers[key]; ok {
+		return r, nil
+	}
+
+	// Use the specified style, or detect one from the terminal
+	styleOption := glamour.WithAutoStyle()
+	if style != "" {
+		styleOption = glamour.WithStylePath(style)
+	}
+
+	// Create a renderer with the selected style
+	r, err := glamour.NewTermRenderer(
+		styleOption,
+		glamour.WithWordWrap(width),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create markdown renderer: %v", err)
+	}
+
+	renderers[key] = r
+	return r, nil
+}
+
+// MarkdownCache memoizes rendered markdown for one width at a time, so
+// redrawing unchanged messages costs nothing
+type MarkdownCache struct {
+	width   int
+	entries map[string]string
+}
+
+// NewMarkdownCache creates an empty cache
+func NewMarkdownCache() *MarkdownCache {
+	return &MarkdownCache{entries: make(map[string]string)}
+}
+
+// Render renders markdown wrapped to width, reusing an earlier result for the
+// same text. Changing the width drops everything rendered for the old one.
+func (c *MarkdownCache) Render(markdown string, width int) (string, error) {
+	if width != c.width {
+		c.width = width
+		c.entries = make(map[string]string)
+	}
+	if rendered, ok := c.entries[markdown]; ok {
+		return rendered, nil
+	}
+
+	rendered, err := RenderMarkdownWidth(markdown, width)
+	if err != nil {
+		return "", err
+	}
+	c.entries[markdown] = rendered
+	return rendered, nil
+}
+
 // MustRenderMarkdown renders markdown text and panics on error
 func MustRenderMarkdown(markdown string) string {
 	rendered, err := RenderMarkdownWithGlamour(markdown)
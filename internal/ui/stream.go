@@ -0,0 +1,245 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+	"golang.org/x/term"
+)
+
+// MarkdownStream renders streamed markdown to a terminal as it arrives.
+// Finished blocks (paragraphs, lists, closed code fences) are rendered and
+// printed once; only the block still being written is redrawn on each
+// write. In plain mode text is passed through untouched.
+type MarkdownStream struct {
+	out   io.Writer
+	plain bool
+
+	// width is the width markdown is wrapped to, and cols and lines the size
+	// of the terminal
+	width int
+	cols  int
+	lines int
+
+	// pending is the markdown that hasn't been committed yet
+	pending string
+
+	// tailRows is the number of terminal rows the drawn tail occupies
+	tailRows int
+
+	// wrote is set once a block has been committed, and split when the
+	// last one was cut short, so the rest follows it without a gap
+	wrote bool
+	split bool
+}
+
+// NewMarkdownStream creates a stream writing to out. Rendering is sized to
+// the terminal out is connected to, if any.
+func NewMarkdownStream(out io.Writer, plain bool) *MarkdownStream {
+	s := &MarkdownStream{out: out, plain: plain, width: DefaultWordWrap, cols: DefaultWordWrap, lines: 24}
+	if f, ok := out.(*os.File); ok {
+		if cols, lines, err := term.GetSize(int(f.Fd())); err == nil && cols > 0 {
+			s.width = min(cols, DefaultWordWrap)
+			s.cols = cols
+			s.lines = lines
+		}
+	}
+	return s
+}
+
+// Write adds streamed text, commits any blocks it completes and redraws
+// the rest
+func (s *MarkdownStream) Write(p []byte) (int, error) {
+	if s.plain {
+		return s.out.Write(p)
+	}
+
+	s.pending += string(p)
+	s.clearTail()
+	for {
+		end := blockEnd(s.pending)
+		if end < 0 {
+			break
+		}
+		s.commit(s.pending[:end])
+		s.pending = strings.TrimLeft(s.pending[end:], "\n")
+	}
+
+	if err := s.drawTail(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close commits whatever is left once the stream has ended
+func (s *MarkdownStream) Close() error {
+	if s.plain {
+		return nil
+	}
+
+	s.clearTail()
+	if strings.TrimSpace(s.pending) != "" {
+		s.commit(s.pending)
+	}
+	s.pending = ""
+	return nil
+}
+
+// commit renders a finished block and prints it for good
+func (s *MarkdownStream) commit(block string) {
+	if strings.TrimSpace(block) == "" {
+		return
+	}
+	if s.wrote && !s.split {
+		fmt.Fprintln(s.out)
+	}
+	fmt.Fprint(s.out, s.render(block))
+	s.wrote = true
+	s.split = false
+}
+
+// drawTail prints the block in progress. If it has grown too tall to be
+// redrawn in place, its complete lines are committed first.
+func (s *MarkdownStream) drawTail() error {
+	if strings.TrimSpace(s.pending) == "" {
+		return nil
+	}
+
+	tail := s.render(s.pending)
+	if s.rows(tail) > s.lines/2 {
+		if end, reopen := splitTail(s.pending); end > 0 {
+			s.commit(s.pending[:end])
+			s.pending = reopen + s.pending[end:]
+			s.split = true
+			return s.drawTail()
+		}
+	}
+
+	if s.wrote && !s.split {
+		tail = "\n" + tail
+	}
+	if _, err := fmt.Fprint(s.out, tail); err != nil {
+		return fmt.Errorf("failed to write response: %v", err)
+	}
+	s.tailRows = s.rows(tail)
+	return nil
+}
+
+// clearTail erases the drawn tail so it can be redrawn or committed
+func (s *MarkdownStream) clearTail() {
+	if s.tailRows == 0 {
+		return
+	}
+	fmt.Fprintf(s.out, "\r\033[%dA\033[J", s.tailRows)
+	s.tailRows = 0
+}
+
+// render renders a block without the blank lines glamour puts around it,
+// ending with a newline. It falls back to the raw text on failure.
+func (s *MarkdownStream) render(block string) string {
+	rendered, err := RenderMarkdownWidth(block, s.width)
+	if err != nil {
+		rendered = block
+	}
+
+	lines := strings.Split(rendered, "\n")
+	for len(lines) > 0 && strings.TrimSpace(ansi.Strip(lines[0])) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(ansi.Strip(lines[len(lines)-1])) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// rows counts the terminal rows text takes up, including lines that wrap
+func (s *MarkdownStream) rows(text string) int {
+	rows := 0
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		rows += max(1, (ansi.StringWidth(line)+s.cols-1)/s.cols)
+	}
+	return rows
+}
+
+// blockEnd finds the end of the first finished block in text: after a blank
+// line outside a code fence, or after a closing fence. It returns -1 if no
+// block is finished yet.
+func blockEnd(text string) int {
+	fence := ""
+	content := false
+	offset := 0
+	for {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return -1
+		}
+		line := text[offset : offset+i]
+		offset += i + 1
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				return offset
+			}
+		case isFence(trimmed):
+			fence = fenceMarker(trimmed)
+			content = true
+		case trimmed == "":
+			if content {
+				return offset
+			}
+		default:
+			content = true
+		}
+	}
+}
+
+// splitTail splits an unfinished block after its last complete line. If
+// that leaves a code fence open, reopen is the fence line to continue the
+// rest with. end is 0 if there's nothing to split off.
+func splitTail(text string) (end int, reopen string) {
+	end = strings.LastIndexByte(text, '\n') + 1
+	fence, fenceStart, fenceEnd := "", 0, 0
+	for offset := 0; offset < end; {
+		i := strings.IndexByte(text[offset:], '\n')
+		line := text[offset : offset+i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fence == "" && isFence(trimmed):
+			fence, fenceStart, fenceEnd = fenceMarker(trimmed), offset, offset+i+1
+		case fence != "" && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "":
+			fence = ""
+		}
+		offset += i + 1
+	}
+
+	if fence == "" {
+		return end, ""
+	}
+	if end == fenceEnd {
+		// No code lines yet; keep the fence with them
+		return fenceStart, ""
+	}
+	return end, text[fenceStart:fenceEnd]
+}
+
+// isFence reports whether a trimmed line opens or closes a code fence
+func isFence(line string) bool {
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+// fenceMarker returns the run of fence characters a fence line starts with
+func fenceMarker(line string) string {
+	n := 0
+	for n < len(line) && line[n] == line[0] {
+		n++
+	}
+	return line[:n]
+}
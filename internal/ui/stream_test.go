@@ -0,0 +1,64 @@
+package ui
+
+import "testing"
+
+func TestBlockEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string // text up to the end of the first block; "" for none
+	}{
+		{name: "empty", text: ""},
+		{name: "unfinished line", text: "Hello wor"},
+		{name: "unfinished paragraph", text: "Hello world\nmore"},
+		{name: "paragraph", text: "Hello world\n\nNext", want: "Hello world\n\n"},
+		{name: "leading blank lines", text: "\n\nHello\n\nNext", want: "\n\nHello\n\n"},
+		{name: "whitespace-only line ends a paragraph", text: "Hello\n  \nNext", want: "Hello\n  \n"},
+		{name: "open fence", text: "```go\nfunc main() {\n\n"},
+		{name: "closed fence", text: "```go\nx := 1\n\ny := 2\n```\nafter", want: "```go\nx := 1\n\ny := 2\n```\n"},
+		{name: "fence after a paragraph", text: "Intro\n```\ncode\n```\n", want: "Intro\n```\ncode\n```\n"},
+		{name: "longer closing fence", text: "```\ncode\n`````\n", want: "```\ncode\n`````\n"},
+		{name: "shorter closing fence stays open", text: "````\n```\ncode\n"},
+		{name: "tilde fence", text: "~~~\ncode\n~~~\n", want: "~~~\ncode\n~~~\n"},
+		{name: "other fence character stays open", text: "~~~\n```\n\ncode\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end := blockEnd(tt.text)
+			got := ""
+			if end >= 0 {
+				got = tt.text[:end]
+			}
+			if got != tt.want || (tt.want == "" && end != -1) {
+				t.Errorf("blockEnd(%q) = %d (%q), want %q", tt.text, end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitTail(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantHead   string
+		wantReopen string
+	}{
+		{name: "no complete line", text: "partial"},
+		{name: "complete lines", text: "one\ntwo\nthr", wantHead: "one\ntwo\n"},
+		{name: "closed fence", text: "```\ncode\n```\ntail", wantHead: "```\ncode\n```\n"},
+		{name: "open fence with code", text: "text\n```go\nx := 1\ny", wantHead: "text\n```go\nx := 1\n", wantReopen: "```go\n"},
+		{name: "open fence without code", text: "text\n```go\nx", wantHead: "text\n"},
+		{name: "only an open fence", text: "```\n"},
+		{name: "indented fence", text: "  ~~~\ncode\n", wantHead: "  ~~~\ncode\n", wantReopen: "  ~~~\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end, reopen := splitTail(tt.text)
+			if tt.text[:end] != tt.wantHead || reopen != tt.wantReopen {
+				t.Errorf("splitTail(%q) = %q, %q; want %q, %q", tt.text, tt.text[:end], reopen, tt.wantHead, tt.wantReopen)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Entry is the token usage of one request in the ledger
+type Entry struct {
+	Time             time.Time `json:"time"`
+	Command          string    `json:"command"`
+	Model            string    `json:"model"`
+	PromptTokens     int32     `json:"prompt_tokens"`
+	CandidatesTokens int32     `json:"candidates_tokens"`
+	TotalTokens      int32     `json:"total_tokens"`
+}
+
+// Ways of grouping entries in a summary
+const (
+	ByModel   = "model"
+	ByDay     = "day"
+	ByCommand = "command"
+)
+
+// Path returns the ledger file: $XDG_DATA_HOME/gemi/usage.jsonl, or
+// ~/.local/share/gemi/usage.jsonl
+func Path() (string, error) {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to locate data directory: %v", err)
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(data, "gemi", "usage.jsonl"), nil
+}
+
+// Append adds an entry to the ledger. Each entry is a single appended line,
+// so gemi processes running at the same time don't corrupt each other's.
+func Append(e Entry) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode usage: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create usage directory: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open usage ledger: %v", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to record usage: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to record usage: %v", err)
+	}
+	return nil
+}
+
+// Load reads the entries recorded since the given time, oldest first. Lines
+// that can't be parsed, such as one cut short by a crash, are skipped.
+func Load(since time.Time) ([]Entry, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %v", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !e.Time.Before(since) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %v", err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// Total sums the entries of one group, or of all of them
+type Total struct {
+	Key              string `json:"key,omitempty"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CandidatesTokens int64  `json:"candidates_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+
+	// Cost is the estimated spend in US dollars of the entries with a
+	// price, and Unpriced counts the entries without one
+	Cost     float64 `json:"cost"`
+	Unpriced int     `json:"unpriced,omitempty"`
+}
+
+// add counts an entry, whose cost is unknown if ok is false
+func (t *Total) add(e Entry, cost float64, ok bool) {
+	t.Requests++
+	t.PromptTokens += int64(e.PromptTokens)
+	t.CandidatesTokens += int64(e.CandidatesTokens)
+	t.TotalTokens += int64(e.TotalTokens)
+	if ok {
+		t.Cost += cost
+	} else {
+		t.Unpriced++
+	}
+}
+
+// Summarize groups entries by model, local day or command, sorted by key,
+// and totals them all. cost estimates an entry's spend, returning false if
+// it can't.
+func Summarize(entries []Entry, by string, cost func(e Entry) (float64, bool)) ([]Total, Total, error) {
+	var key func(e Entry) string
+	switch by {
+	case ByModel:
+		key = func(e Entry) string { return e.Model }
+	case ByDay:
+		key = func(e Entry) string { return e.Time.Local().Format("2006-01-02") }
+	case ByCommand:
+		key = func(e Entry) string { return e.Command }
+	default:
+		return nil, Total{}, fmt.Errorf("unknown grouping %q (use model, day or command)", by)
+	}
+
+	groups := make(map[string]*Total)
+	var all Total
+	for _, e := range entries {
+		k := key(e)
+		if groups[k] == nil {
+			groups[k] = &Total{Key: k}
+		}
+		c, ok := cost(e)
+		groups[k].add(e, c, ok)
+		all.add(e, c, ok)
+	}
+
+	totals := make([]Total, 0, len(groups))
+	for _, t := range groups {
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].Key < totals[j].Key
+	})
+	return totals, all, nil
+}